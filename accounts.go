@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+// accountLabel - the label naming the account of a project, only with accounts configured
+const accountLabel = "account"
+
+// AccountConfig - a rollbar account scraped by the exporter. Its tokens are taken
+// from an environment variable or a file, so they never show up in the config.
+type AccountConfig struct {
+	Name                 string `yaml:"name"`
+	BaseURL              string `yaml:"base_url"`
+	ReadTokenEnv         string `yaml:"read_token_env"`
+	ReadTokenFile        string `yaml:"read_token_file"`
+	WriteTokenEnv        string `yaml:"write_token_env"`
+	WriteTokenFile       string `yaml:"write_token_file"`
+	IncludeProjectsRegex string `yaml:"include_projects_regex"`
+	ExcludeProjectsRegex string `yaml:"exclude_projects_regex"`
+
+	// compiled by validate
+	includeProjects *regexp.Regexp
+	excludeProjects *regexp.Regexp
+}
+
+func validateAccounts(as []AccountConfig) error {
+	names := make(map[string]bool)
+	for i := range as {
+		a := &as[i]
+		if a.Name == "" {
+			return fmt.Errorf("accounts[%d]: name is required", i)
+		}
+		if names[a.Name] {
+			return fmt.Errorf("accounts[%d]: duplicated name %s", i, a.Name)
+		}
+		names[a.Name] = true
+		if a.BaseURL != "" {
+			if u, err := url.Parse(a.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("account %s: base_url %q is not a valid URL", a.Name, a.BaseURL)
+			}
+		}
+		if (a.ReadTokenEnv == "") == (a.ReadTokenFile == "") {
+			return fmt.Errorf("account %s: either read_token_env or read_token_file is required", a.Name)
+		}
+		if a.WriteTokenEnv != "" && a.WriteTokenFile != "" {
+			return fmt.Errorf("account %s: only one of write_token_env and write_token_file is allowed", a.Name)
+		}
+		var err error
+		if a.IncludeProjectsRegex != "" {
+			if a.includeProjects, err = compileRegex(fmt.Sprintf("account %s: include_projects_regex", a.Name), a.IncludeProjectsRegex); err != nil {
+				return err
+			}
+		}
+		if a.ExcludeProjectsRegex != "" {
+			if a.excludeProjects, err = compileRegex(fmt.Sprintf("account %s: exclude_projects_regex", a.Name), a.ExcludeProjectsRegex); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// account - an account in effect, with its project filters
+type account struct {
+	*rollbar.Account
+	includeProjects *regexp.Regexp
+	excludeProjects *regexp.Regexp
+}
+
+// defaultAccount - the account of $ROLLBAR_ACCOUNT_READ_TOKEN and $ROLLBAR_ACCOUNT_WRITE_TOKEN, without any account configured
+var defaultAccount = &account{Account: rollbar.DefaultAccount}
+
+var (
+	// accounts - the accounts scraped, set by loadAccounts
+	accounts = []*account{defaultAccount}
+	// projectAccounts - project ID to its account, resolved by listProjects
+	projectAccountsMu sync.RWMutex
+	projectAccounts   = map[int]*account{}
+)
+
+// multiAccount - whether the accounts are configured, so the metrics of projects are labeled by account
+func multiAccount() bool {
+	return len(Accounts) > 0
+}
+
+// loadAccounts - set up the accounts of the config and their tokens, the default account if none,
+// and the project tokens file
+func loadAccounts(c Config) error {
+	var as []*account
+	var ss []*secret
+	if len(c.Accounts) == 0 {
+		as = []*account{defaultAccount}
+		ss = envSecrets(defaultAccount.Account, c.ReadOnly)
+	}
+	for _, ac := range c.Accounts {
+		a := &account{
+			Account:         rollbar.NewAccount(ac.Name, ac.BaseURL),
+			includeProjects: ac.includeProjects,
+			excludeProjects: ac.excludeProjects,
+		}
+		as = append(as, a)
+		ss = append(ss, &secret{
+			Name:     fmt.Sprintf("read token of account %s", ac.Name),
+			Label:    ac.Name + "_read",
+			Set:      setToken(a.SetReadAccessToken),
+			env:      ac.ReadTokenEnv,
+			path:     ac.ReadTokenFile,
+			required: true,
+		})
+		if ac.WriteTokenEnv == "" && ac.WriteTokenFile == "" {
+			continue
+		}
+		if c.ReadOnly {
+			logrus.Warnf("the write token of account %s is ignored in read only mode", ac.Name)
+			continue
+		}
+		ss = append(ss, &secret{
+			Name:  fmt.Sprintf("write token of account %s", ac.Name),
+			Label: ac.Name + "_write",
+			Set:   setToken(a.SetWriteAccessToken),
+			env:   ac.WriteTokenEnv,
+			path:  ac.WriteTokenFile,
+		})
+	}
+	if c.ProjectTokensFile != "" {
+		ss = append(ss, &secret{
+			Name:  "project tokens file",
+			Label: "project_tokens",
+			Set:   projectTokensSetter(c.Accounts),
+			path:  c.ProjectTokensFile,
+		})
+	}
+	if err := loadSecrets(ss); err != nil {
+		return err
+	}
+	accounts = as
+	return nil
+}
+
+// accountOf - the account of the project, the first one if the project isn't listed yet
+func accountOf(projectID int) *account {
+	projectAccountsMu.RLock()
+	defer projectAccountsMu.RUnlock()
+	if a, ok := projectAccounts[projectID]; ok {
+		return a
+	}
+	return accounts[0]
+}
+
+// listProjects - the projects of all accounts. The failed accounts are logged and
+// counted, an error is returned only if every account failed.
+func listProjects() ([]rollbar.Project, error) {
+	result := make([]rollbar.Project, 0)
+	resolved := make(map[int]*account)
+	var lastErr error
+	for _, a := range accounts {
+		ps, err := a.ListProjects()
+		if err != nil {
+			if multiAccount() {
+				logrus.Errorf("ListProjects failed - account: %s, %v", a.Name, err)
+			} else {
+				logrus.Errorf("ListProjects failed - %v", err)
+			}
+			countScrapeError(a, stageListProjects)
+			lastErr = err
+			continue
+		}
+		for _, p := range ps {
+			resolved[p.ID] = a
+		}
+		result = append(result, ps...)
+	}
+	if len(result) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+
+	projectAccountsMu.Lock()
+	defer projectAccountsMu.Unlock()
+	// keep the accounts failed this time, their metrics are still exposed
+	for id, a := range resolved {
+		projectAccounts[id] = a
+	}
+	return result, nil
+}
+
+// accountGatherer - labels the metrics of projects with their account, by the project_id label.
+// exporter_projects and exporter_scrape_errors_total have their own account label, see accountLabels.
+// The SLOs could cover projects of several accounts, so they are totals without the account label.
+type accountGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g accountGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	for _, mf := range mfs {
+		labelAccounts(mf)
+	}
+	return mfs, err
+}
+
+// labelAccounts - add the account label to the metrics of the family with a project_id label
+func labelAccounts(mf *dto.MetricFamily) {
+	if !multiAccount() {
+		return
+	}
+	projectAccountsMu.RLock()
+	defer projectAccountsMu.RUnlock()
+	for _, m := range mf.Metric {
+		for _, l := range m.Label {
+			if l.GetName() != "project_id" {
+				continue
+			}
+			id, _ := strconv.Atoi(l.GetValue())
+			if a, ok := projectAccounts[id]; ok {
+				// the label pairs may be shared with other metrics, don't append in place
+				labels := make([]*dto.LabelPair, 0, len(m.Label)+1)
+				labels = append(labels, m.Label...)
+				labels = append(labels, &dto.LabelPair{
+					Name:  proto.String(accountLabel),
+					Value: proto.String(a.Name),
+				})
+				sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+				m.Label = labels
+			}
+			break
+		}
+	}
+}
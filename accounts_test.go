@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_AccountGatherer(t *testing.T) {
+	reset(t)
+	defer func(as []AccountConfig) { Accounts = as }(Accounts)
+	Accounts = []AccountConfig{{Name: "acme"}}
+	projectAccounts = map[int]*account{1: {Account: rollbar.NewAccount("acme", "")}}
+
+	reg := prometheus.NewRegistry()
+	status := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "project_status", Help: "status"}, []string{"project_id"})
+	total := prometheus.NewGauge(prometheus.GaugeOpts{Name: "slo_objective", Help: "objective"})
+	reg.MustRegister(status, total)
+	status.WithLabelValues("1").Set(1)
+	status.WithLabelValues("2").Set(1)
+	total.Set(2)
+
+	mfs, err := accountGatherer{reg}.Gather()
+	ok(t, err)
+	labels := make(map[string][]map[string]string)
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			ls := make(map[string]string)
+			for _, l := range m.Label {
+				ls[l.GetName()] = l.GetValue()
+			}
+			labels[mf.GetName()] = append(labels[mf.GetName()], ls)
+		}
+	}
+	equals(t, []map[string]string{
+		{"project_id": "1", accountLabel: "acme"},
+		// the account of the project is unknown
+		{"project_id": "2"},
+	}, labels["project_status"])
+	// a total over the accounts has no account label
+	equals(t, []map[string]string{{}}, labels["slo_objective"])
+}
+
+func Test_AccountExporterMetrics(t *testing.T) {
+	defer func(as []AccountConfig, a []*account, i bool) { Accounts, accounts, ItemMetrics = as, a, i }(Accounts, accounts, ItemMetrics)
+	ItemMetrics = false
+
+	// acme is scraped fine, the projects of globex can't be listed, then its token
+	globexDown := true
+	acme := httptest.NewServer(fakeRollbar(`[[{"field":"environment","value":"production"},{"field":"occurrence_count","value":3}]]`))
+	globex := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/projects" && !globexDown:
+			fmt.Fprint(w, `{"err":0,"result":[{"id":2,"name":"web","status":"enabled"}]}`)
+		default:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(acme.Close)
+	t.Cleanup(globex.Close)
+	Accounts = []AccountConfig{{Name: "acme"}, {Name: "globex"}}
+	accounts = []*account{
+		{Account: rollbar.NewAccount("acme", acme.URL)},
+		{Account: rollbar.NewAccount("globex", globex.URL)},
+	}
+	reset(t)
+
+	ok(t, scrape())
+	equals(t, 1.0, testutil.ToFloat64(projects.With(prometheus.Labels{"state": "processed", accountLabel: "acme"})))
+	equals(t, 0.0, testutil.ToFloat64(projects.With(prometheus.Labels{"state": "processed", accountLabel: "globex"})))
+	equals(t, 0.0, testutil.ToFloat64(scrapeErrors.With(prometheus.Labels{"stage": stageListProjects, accountLabel: "acme"})))
+	equals(t, 1.0, testutil.ToFloat64(scrapeErrors.With(prometheus.Labels{"stage": stageListProjects, accountLabel: "globex"})))
+
+	globexDown = false
+	ok(t, scrape())
+	equals(t, 1.0, testutil.ToFloat64(projects.With(prometheus.Labels{"state": "processed", accountLabel: "globex"})))
+	equals(t, 0.0, testutil.ToFloat64(scrapeErrors.With(prometheus.Labels{"stage": stageToken, accountLabel: "acme"})))
+	equals(t, 1.0, testutil.ToFloat64(scrapeErrors.With(prometheus.Labels{"stage": stageToken, accountLabel: "globex"})))
+	equals(t, 8, testutil.CollectAndCount(scrapeErrors))
+}
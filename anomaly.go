@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// minBaseline - an item baseline decayed below this rate per minute is forgotten
+const minBaseline = 0.001
+
+var (
+	projectAnomalyScore = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "project_anomaly_score",
+		Help: "This is how many standard deviations the occurrence rate of a project is above its baseline",
+	}, []string{
+		"project_id",
+	})
+
+	projectSpiking = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "project_spiking",
+		Help: "This is 1 if the anomaly score of a project reaches the threshold, otherwise 0",
+	}, []string{
+		"project_id",
+	})
+)
+
+// per-item anomaly metrics, created by newAnomalyMetrics once the label allowlists are configured
+var (
+	itemAnomalyScoreLabels itemLabels
+	itemAnomalyScore       *prometheus.GaugeVec
+
+	itemSpikingLabels itemLabels
+	itemSpiking       *prometheus.GaugeVec
+)
+
+func newAnomalyMetrics() {
+	itemAnomalyScoreLabels = newItemLabels("item_anomaly_score",
+		"project_id",
+		"item_id",
+		"environment",
+	)
+	itemAnomalyScore = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: itemAnomalyScoreLabels.name,
+		Help: "This is how many standard deviations the occurrence rate of an item is above its baseline",
+	}, itemAnomalyScoreLabels.names)
+
+	itemSpikingLabels = newItemLabels("item_spiking",
+		"project_id",
+		"item_id",
+		"environment",
+	)
+	itemSpiking = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: itemSpikingLabels.name,
+		Help: "This is 1 if the anomaly score of an item reaches the threshold, otherwise 0",
+	}, itemSpikingLabels.names)
+}
+
+func registerAnomalyMetrics() {
+	registry.MustRegister(projectAnomalyScore)
+	registry.MustRegister(projectSpiking)
+	if ItemMetrics {
+		registry.MustRegister(itemAnomalyScore)
+		registry.MustRegister(itemSpiking)
+	}
+}
+
+// observeRate - score the occurrences of the window against the baseline of key,
+// then fold them into it. Returns false while the baseline is warming up.
+func observeRate(key string, count float64, window time.Duration) (float64, bool) {
+	rate := count / window.Minutes()
+	b := st.Baselines[key]
+	score, ready := b.Score(rate), b.Count >= AnomalyWarmup
+	b.Update(rate, AnomalyAlpha)
+	st.Baselines[key] = b
+	return score, ready
+}
+
+func spiking(score float64) float64 {
+	if score >= AnomalyThreshold {
+		return 1
+	}
+	return 0
+}
+
+// observeProjectAnomaly - update the baseline and anomaly metrics of the project from its rollups
+func observeProjectAnomaly(projectID int, rows []rollbar.OccurrenceRow, window time.Duration) {
+	if window < time.Minute {
+		return
+	}
+	total := 0.0
+	for _, row := range rows {
+		if matchEnvironment(projectID, row.String(rollbar.FieldEnvironment)) {
+			total += float64(row.Int(rollbar.FieldOccurrenceCount))
+		}
+	}
+	pid := fmt.Sprintf("%d", projectID)
+	score, ready := observeRate("project:"+pid, total, window)
+	if !ready {
+		return
+	}
+	projectAnomalyScore.WithLabelValues(pid).Set(score)
+	projectSpiking.WithLabelValues(pid).Set(spiking(score))
+}
+
+// observeItemAnomalies - update the baselines of the items from the window totals. Items
+// with a baseline but no occurrence are observed as 0, so their baselines decay. Only the
+// top items are exposed, but every item keeps its own baseline.
+func observeItemAnomalies(projectID int, totals map[itemEnv]int64, top map[int]bool, window time.Duration) {
+	if window < time.Minute {
+		return
+	}
+	pid := fmt.Sprintf("%d", projectID)
+	itemAnomalyScore.DeletePartialMatch(prometheus.Labels{"project_id": pid})
+	itemSpiking.DeletePartialMatch(prometheus.Labels{"project_id": pid})
+
+	// baselines are keyed by item:<project_id>:<item_id>:<environment>
+	prefix := "item:" + pid + ":"
+	counts := make(map[itemEnv]float64)
+	for key, total := range totals {
+		counts[key] = float64(total)
+	}
+	for key := range st.Baselines {
+		id, env, ok := strings.Cut(strings.TrimPrefix(key, prefix), ":")
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		itemID, err := strconv.Atoi(id)
+		if err != nil {
+			continue
+		}
+		if _, ok := counts[itemEnv{itemID, env}]; !ok {
+			counts[itemEnv{itemID, env}] = 0
+		}
+	}
+
+	for ie, count := range counts {
+		key := fmt.Sprintf("%s%d:%s", prefix, ie.ItemID, ie.Environment)
+		score, ready := observeRate(key, count, window)
+		if count == 0 && st.Baselines[key].Mean < minBaseline {
+			delete(st.Baselines, key)
+		}
+		if !ready || !top[ie.ItemID] {
+			continue
+		}
+		labels := prometheus.Labels{
+			"project_id":  pid,
+			"item_id":     fmt.Sprintf("%d", ie.ItemID),
+			"environment": ie.Environment,
+		}
+		if l, ok := itemAnomalyScoreLabels.of(labels); ok {
+			itemAnomalyScore.With(l).Set(score)
+		}
+		if l, ok := itemSpikingLabels.of(labels); ok {
+			itemSpiking.With(l).Set(spiking(score))
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/anomaly"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_ObserveItemAnomalies(t *testing.T) {
+	defer func(a bool, alpha, threshold float64, warmup int) {
+		AnomalyDetection, AnomalyAlpha, AnomalyThreshold, AnomalyWarmup = a, alpha, threshold, warmup
+	}(AnomalyDetection, AnomalyAlpha, AnomalyThreshold, AnomalyWarmup)
+	AnomalyDetection, AnomalyAlpha, AnomalyThreshold, AnomalyWarmup = true, 0.5, 3, 2
+	reset(t)
+
+	st.Baselines = map[string]anomaly.EWMA{
+		// an item not seen any more, its environment has a colon
+		"item:1:5:prod:eu": {Mean: 4, Count: 5},
+		// an item about to be forgotten
+		"item:1:6:production": {Mean: 0.0015, Count: 5},
+		// not an item of the project
+		"item:10:5:production": {Mean: 4, Count: 5},
+		"item:1:x:production":  {Mean: 4, Count: 5},
+		"project:1":            {Mean: 4, Count: 5},
+	}
+	item1 := prometheus.Labels{"project_id": "1", "item_id": "1", "environment": "production"}
+	observe := func(count int64) {
+		observeItemAnomalies(1, map[itemEnv]int64{{1, "production"}: count}, map[int]bool{1: true}, time.Minute)
+	}
+
+	// the baseline of a new item warms up before it's exposed
+	observe(10)
+	observe(10)
+	equals(t, 0, testutil.CollectAndCount(itemAnomalyScore))
+	equals(t, 10.0, st.Baselines["item:1:1:production"].Mean)
+
+	// the baselines of the items not seen decay, the ones below minBaseline are forgotten
+	equals(t, 1.0, st.Baselines["item:1:5:prod:eu"].Mean)
+	_, ok := st.Baselines["item:1:6:production"]
+	assert(t, !ok, "the decayed baseline is kept")
+	for _, key := range []string{"item:10:5:production", "item:1:x:production", "project:1"} {
+		equals(t, anomaly.EWMA{Mean: 4, Count: 5}, st.Baselines[key])
+	}
+
+	// only the items in the top are exposed
+	observe(100)
+	equals(t, 1, testutil.CollectAndCount(itemAnomalyScore))
+	equals(t, 1, testutil.CollectAndCount(itemSpiking))
+	// the deviation of a steady baseline is the square root of its mean
+	equals(t, 90/math.Sqrt(10), testutil.ToFloat64(itemAnomalyScore.With(item1)))
+	equals(t, 1.0, testutil.ToFloat64(itemSpiking.With(item1)))
+
+	observe(10)
+	equals(t, 0.0, testutil.ToFloat64(itemSpiking.With(item1)))
+}
+
+func Test_ProjectAnomaly(t *testing.T) {
+	defer func(a, i bool, warmup int, interval time.Duration) {
+		AnomalyDetection, ItemMetrics, AnomalyWarmup, ScrapeInterval = a, i, warmup, interval
+	}(AnomalyDetection, ItemMetrics, AnomalyWarmup, ScrapeInterval)
+	AnomalyDetection, ItemMetrics, AnomalyWarmup, ScrapeInterval = true, false, 12, time.Minute
+	reset(t)
+	serve(t, fakeRollbar(`[[{"field":"environment","value":"production"},{"field":"occurrence_count","value":30}]]`))
+	projectAnomalyScore.Reset()
+	projectSpiking.Reset()
+
+	// a baseline warming up isn't scored
+	st.Baselines["project:1"] = anomaly.EWMA{Mean: 4, Count: 11}
+	ok(t, scrape())
+	equals(t, 0, testutil.CollectAndCount(projectAnomalyScore))
+	equals(t, 12, st.Baselines["project:1"].Count)
+
+	// the next window is a whole minute again, not continued from the watermark
+	st.Baselines["project:1"] = anomaly.EWMA{Mean: 4, Count: 12}
+	delete(st.Watermarks, 1)
+	ok(t, scrape())
+	equals(t, (30-4)/2.0, testutil.ToFloat64(projectAnomalyScore.WithLabelValues("1")))
+	equals(t, 1.0, testutil.ToFloat64(projectSpiking.WithLabelValues("1")))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+// backfillResult - history of the occurrence metrics, walked from the metrics API
+type backfillResult struct {
+	Scraped   *timeSeries
+	PerMinute *timeSeries
+}
+
+// maxBackfillPeriod - the longest period a backfill may walk
+const maxBackfillPeriod = 7 * 24 * time.Hour
+
+// backfillTarget - a selected project with its token and settings, taken under the cycle lock
+type backfillTarget struct {
+	Project  rollbar.Project
+	Token    string
+	Settings projectSettings
+}
+
+// backfillTargets - the selected projects to backfill, called with the cycle lock held
+func backfillTargets() ([]backfillTarget, error) {
+	ps, err := listProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	resolveOverrides(ps)
+
+	targets := make([]backfillTarget, 0, len(ps))
+	for _, p := range ps {
+		if !selectProject(p) {
+			continue
+		}
+		token, err := projectToken(p)
+		if err != nil {
+			logrus.Errorf("GetOrCreateProjectReadToken failed - project: [%d]%s, %v", p.ID, p.Name, err)
+			continue
+		}
+		targets = append(targets, backfillTarget{p, token, settingsOf(p.ID)})
+	}
+	return targets, nil
+}
+
+// backfill - walk the occurrences of the last period in windows. When seed is
+// set, the live counters of projects without a watermark continue from the
+// backfilled totals, and scraping resumes from the end of the backfill.
+// The cycle lock is only held to read and update the state, so scraping goes
+// on while the windows are queried.
+func backfill(period time.Duration, seed bool) (*backfillResult, error) {
+	result := &backfillResult{
+		Scraped: newCounterSeries(
+			"item_occurrences_scraped_total",
+			"This is the counter of occurrences of an item summed over the scraped windows",
+		),
+		PerMinute: newTimeSeries(
+			"item_occurrences_per_minute",
+			"This is the occurrences of an item per minute, only with minute resolution",
+		),
+	}
+
+	cycle.Lock()
+	window := BackfillWindow
+	targets, err := backfillTargets()
+	cycle.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	end := time.Now().Truncate(time.Minute)
+	start := end.Add(-period)
+
+	// series of the scraped counter to its backfilled total, by project to seed
+	seeds := make(map[int]map[string]float64)
+	labels := make(map[string]prometheus.Labels)
+	for _, t := range targets {
+		p := t.Project
+		logrus.Infof("backfill project [%d]%s from %s", p.ID, p.Name, start)
+
+		pid := fmt.Sprintf("%d", p.ID)
+		// cumulated by the series of the scraped counter, which may drop labels
+		totals := make(map[string]float64)
+		failed := false
+		for from := start; from.Before(end); from = from.Add(window) {
+			to := from.Add(window)
+			if to.After(end) {
+				to = end
+			}
+			params := rollbar.NewItemOccurrencesInputRange(from, to, 0, 0)
+			if MinuteResolution {
+				params = params.WithGranularity(rollbar.GranularityMinute)
+			}
+			// as scraping, the items out of the top ones are folded into "other" rather than dropped
+			occs, err := accountOf(p.ID).QueryItemOccurrences(t.Token, params, 0)
+			if err != nil {
+				logrus.Errorf("QueryItemOccurrences failed - project: [%d]%s, %v", p.ID, p.Name, err)
+				cycle.Lock()
+				dropToken(p, t.Token, err)
+				cycle.Unlock()
+				failed = true
+				break
+			}
+
+			windowTotals := make(map[itemEnv]int64)
+			for _, occ := range occs {
+				if t.Settings.matchEnvironment(occ.Environment) {
+					windowTotals[itemEnv{occ.ItemID, occ.Environment}] += occ.OccurrenceCount
+				}
+			}
+			top := topItems(windowTotals, minPositive(t.Settings.TopItems, t.Settings.MaxItems))
+
+			// cumulate in time order, so the counter samples are monotonic
+			sort.SliceStable(occs, func(i, j int) bool { return occs[i].Time.Before(occs[j].Time) })
+			for _, occ := range occs {
+				if !t.Settings.matchEnvironment(occ.Environment) {
+					continue
+				}
+				itemID := fmt.Sprintf("%d", occ.ItemID)
+				if !top[occ.ItemID] {
+					itemID = otherItemID
+				}
+				full := prometheus.Labels{
+					"project_id":  pid,
+					"item_id":     itemID,
+					"environment": occ.Environment,
+				}
+				l := scrapedLabels.project(full)
+				key := seriesKey(l)
+				labels[key] = l
+				totals[key] += float64(occ.OccurrenceCount)
+				if MinuteResolution {
+					result.PerMinute.Add(perMinuteLabels.project(full), occ.Time, float64(occ.OccurrenceCount))
+					result.Scraped.Add(l, occ.Time.Add(time.Minute), totals[key])
+				}
+			}
+			if !MinuteResolution {
+				for key, total := range totals {
+					result.Scraped.Add(labels[key], to, total)
+				}
+			}
+		}
+
+		if !failed && seed {
+			seeds[p.ID] = totals
+		}
+	}
+
+	if len(seeds) == 0 {
+		return result, nil
+	}
+	cycle.Lock()
+	defer cycle.Unlock()
+	for _, t := range targets {
+		totals, ok := seeds[t.Project.ID]
+		if !ok {
+			continue
+		}
+		if _, ok := st.Watermarks[t.Project.ID]; ok {
+			logrus.Infof("project [%d]%s has a watermark, counters are not seeded", t.Project.ID, t.Project.Name)
+			continue
+		}
+		for key, total := range totals {
+			if l, ok := scrapedLabels.of(labels[key]); ok {
+				occurrencesScraped.Add(l, total)
+			}
+		}
+		st.Watermarks[t.Project.ID] = end.Unix()
+	}
+	return result, nil
+}
+
+// startupBackfill - backfill BackfillPeriod before the first scrape, and write it to BackfillOutput
+func startupBackfill() {
+	logrus.Infof("backfill the last %s in windows of %s...", BackfillPeriod, BackfillWindow)
+	result, err := backfill(BackfillPeriod, true)
+	if err != nil {
+		logrus.Errorf("backfill failed - %v", err)
+		return
+	}
+	if BackfillOutput == "" {
+		return
+	}
+	f, err := os.Create(BackfillOutput)
+	if err != nil {
+		logrus.Errorf("create backfill output failed - %v", err)
+		return
+	}
+	defer f.Close()
+	if err := writeOpenMetrics(f, result.Scraped, result.PerMinute); err != nil {
+		logrus.Errorf("write backfill output failed - %v", err)
+		return
+	}
+	logrus.Infof("backfill is written to %s", BackfillOutput)
+}
+
+// backfilling - held by the on-demand backfill in progress, only one runs at a time
+var backfilling sync.Mutex
+
+// backfillHandler - backfill on demand, ?period=24h (defaults to BackfillPeriod, at most maxBackfillPeriod),
+// responds the OpenMetrics for promtool tsdb create-blocks-from openmetrics. Only served with BackfillEndpoint.
+func backfillHandler(w http.ResponseWriter, r *http.Request) {
+	period := BackfillPeriod
+	if v := r.URL.Query().Get("period"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			http.Error(w, fmt.Sprintf("invalid period %s", strconv.Quote(v)), http.StatusBadRequest)
+			return
+		}
+		period = d
+	}
+	if period <= 0 {
+		http.Error(w, "period is required", http.StatusBadRequest)
+		return
+	}
+	if period > maxBackfillPeriod {
+		http.Error(w, fmt.Sprintf("period should be at most %s", maxBackfillPeriod), http.StatusBadRequest)
+		return
+	}
+	if !backfilling.TryLock() {
+		http.Error(w, "a backfill is in progress", http.StatusTooManyRequests)
+		return
+	}
+	defer backfilling.Unlock()
+	result, err := backfill(period, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", string(expfmt.FmtOpenMetrics))
+	if err := writeOpenMetrics(w, result.Scraped, result.PerMinute); err != nil {
+		logrus.Errorf("write backfill failed - %v", err)
+	}
+}
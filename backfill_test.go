@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const backfillRows = `[[{"field":"item_id","value":1},{"field":"environment","value":"production"},{"field":"occurrence_count","value":3}]]`
+
+func Test_BackfillHandler(t *testing.T) {
+	reset(t)
+	serve(t, fakeRollbar(backfillRows))
+
+	for _, c := range []struct {
+		Query  string
+		Status int
+	}{
+		{"", http.StatusBadRequest},
+		{"?period=abc", http.StatusBadRequest},
+		{"?period=30s", http.StatusBadRequest},
+		{"?period=720h", http.StatusBadRequest},
+		{"?period=2h", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		backfillHandler(w, httptest.NewRequest("GET", "/backfill"+c.Query, nil))
+		equals(t, c.Status, w.Code)
+		if c.Status == http.StatusOK {
+			assert(t, strings.Contains(w.Body.String(), metricName("item_occurrences_scraped_total")+`{environment="production",item_id="1",project_id="1"} 6`),
+				"two windows of 3 occurrences are cumulated, got %s", w.Body.String())
+		}
+	}
+}
+
+func Test_BackfillHandlerBusy(t *testing.T) {
+	backfilling.Lock()
+	defer backfilling.Unlock()
+
+	w := httptest.NewRecorder()
+	backfillHandler(w, httptest.NewRequest("GET", "/backfill?period=1h", nil))
+	equals(t, http.StatusTooManyRequests, w.Code)
+}
+
+func Test_BackfillSeed(t *testing.T) {
+	reset(t)
+	locked := false
+	api := fakeRollbar(backfillRows)
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics/occurrences" {
+			// scraping should go on while the windows are queried
+			if cycle.TryLock() {
+				cycle.Unlock()
+			} else {
+				locked = true
+			}
+		}
+		api(w, r)
+	})
+
+	_, err := backfill(time.Hour, true)
+	ok(t, err)
+	assert(t, !locked, "the cycle lock is held while querying")
+	_, seeded := st.Watermarks[1]
+	assert(t, seeded, "scraping resumes from the end of the backfill")
+	samples := occurrencesScraped.samples()
+	equals(t, 1, len(samples))
+	equals(t, 3.0, samples[0].Value)
+
+	// a project with a watermark is not seeded again
+	_, err = backfill(time.Hour, true)
+	ok(t, err)
+	equals(t, 3.0, occurrencesScraped.samples()[0].Value)
+}
+
+func Test_BackfillFoldsItemsOverMaxItems(t *testing.T) {
+	reset(t)
+	defer func(max int) { MaxItemsPerProject = max }(MaxItemsPerProject)
+	MaxItemsPerProject = 1
+	serve(t, fakeRollbar(`[[{"field":"item_id","value":1},{"field":"environment","value":"production"},{"field":"occurrence_count","value":3}],`+
+		`[{"field":"item_id","value":2},{"field":"environment","value":"production"},{"field":"occurrence_count","value":1}]]`))
+
+	_, err := backfill(time.Hour, true)
+	ok(t, err)
+	values := make(map[string]float64)
+	for _, s := range occurrencesScraped.samples() {
+		values[s.Labels["item_id"]] = s.Value
+	}
+	equals(t, map[string]float64{"1": 3, otherItemID: 1}, values)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// otherItemID - items folded out of the top N are reported under this item_id
+const otherItemID = "other"
+
+// identityLabels - never dropped by the allowlists, so series of different items never merge
+var identityLabels = map[string]bool{
+	"project_id": true,
+	"item_id":    true,
+}
+
+// allowlistLabels - the per-item metrics and their labels which LABEL_ALLOWLIST can drop
+var allowlistLabels = map[string][]string{
+	"item_anomaly_score":                      {"environment"},
+	"item_first_occurrence_timestamp_seconds": {},
+	"item_last_occurrence_timestamp_seconds":  {},
+	"item_occurrences":                        {"environment"},
+	"item_occurrences_last_minute":            {"environment"},
+	"item_occurrences_per_minute":             {"environment"},
+	"item_occurrences_scraped_total":          {"environment"},
+	"item_spiking":                            {"environment"},
+	"item_status":                             {"title", "counter_id", "environment", "platform", "framework", "hash", "status", "level"},
+	"item_total_occurrences":                  {},
+}
+
+func validateLabelAllowlist(allowlist map[string][]string) error {
+	for name, labels := range allowlist {
+		known, ok := allowlistLabels[name]
+		if !ok {
+			return fmt.Errorf("label_allowlist: %q is not a per-item metric", name)
+		}
+		for _, label := range labels {
+			if !identityLabels[label] && !contains(known, label) {
+				return fmt.Errorf("label_allowlist: %q is not a label of %s", label, name)
+			}
+		}
+	}
+	return nil
+}
+
+// itemLabels - label names of a per-item metric after applying its allowlist
+type itemLabels struct {
+	name  string
+	names []string
+}
+
+func newItemLabels(name string, names ...string) itemLabels {
+	allowed, ok := LabelAllowlist[name]
+	if !ok {
+		return itemLabels{name: name, names: names}
+	}
+	keep := make(map[string]bool, len(allowed))
+	for _, label := range allowed {
+		keep[label] = true
+	}
+	result := make([]string, 0, len(names))
+	for _, label := range names {
+		if identityLabels[label] || keep[label] {
+			result = append(result, label)
+		}
+	}
+	return itemLabels{name: name, names: result}
+}
+
+// project - only the allowed labels
+func (il itemLabels) project(labels prometheus.Labels) prometheus.Labels {
+	result := make(prometheus.Labels, len(il.names))
+	for _, name := range il.names {
+		result[name] = labels[name]
+	}
+	return result
+}
+
+// of - the allowed labels, false if the series is dropped by the series cap
+func (il itemLabels) of(labels prometheus.Labels) (prometheus.Labels, bool) {
+	projected := il.project(labels)
+	if !limiter.allow(il.name, projected) {
+		return nil, false
+	}
+	return projected, true
+}
+
+// seriesLimiter - caps the number of per-item series across all metrics
+type seriesLimiter struct {
+	mu sync.Mutex
+	// seen - series key to the item of the series, see itemKey
+	seen map[string]string
+	// written - item to the unix time its series were last written
+	written map[string]int64
+}
+
+func newSeriesLimiter() *seriesLimiter {
+	return &seriesLimiter{seen: make(map[string]string), written: make(map[string]int64)}
+}
+
+var limiter = newSeriesLimiter()
+
+// itemKey - project_id/item_id of the series, project_id/ for the ones of a whole project
+func itemKey(labels prometheus.Labels) string {
+	return labels["project_id"] + "/" + labels["item_id"]
+}
+
+func (l *seriesLimiter) allow(name string, labels prometheus.Labels) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := name + "\xff" + seriesKey(labels)
+	item := itemKey(labels)
+	if _, ok := l.seen[key]; ok {
+		l.written[item] = time.Now().Unix()
+		return true
+	}
+	if MaxSeries > 0 && len(l.seen) >= MaxSeries {
+		seriesDropped.WithLabelValues(name).Inc()
+		return false
+	}
+	l.seen[key] = item
+	l.written[item] = time.Now().Unix()
+	itemSeries.Set(float64(len(l.seen)))
+	return true
+}
+
+// stale - the items whose series were last written before the unix time, the series of whole projects excluded
+func (l *seriesLimiter) stale(before int64) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := make([]string, 0)
+	for item, t := range l.written {
+		if t < before && !strings.HasSuffix(item, "/") {
+			result = append(result, item)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// release - free the slots of the series of the item
+func (l *seriesLimiter) release(item string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, i := range l.seen {
+		if i == item {
+			delete(l.seen, key)
+		}
+	}
+	delete(l.written, item)
+	itemSeries.Set(float64(len(l.seen)))
+}
+
+// staleSeriesAfter - the per-item series not written for this long are deleted, freeing their slots
+const staleSeriesAfter = 24 * time.Hour
+
+// expireItemSeries - delete the per-item series of the items which are stale at now
+func expireItemSeries(now time.Time) {
+	for _, item := range limiter.stale(now.Add(-staleSeriesAfter).Unix()) {
+		pid, id, _ := strings.Cut(item, "/")
+		labels := prometheus.Labels{"project_id": pid, "item_id": id}
+		deleted := 0
+		for _, v := range []*prometheus.GaugeVec{
+			occurrences,
+			itemStatus,
+			occurrencesLastMinute,
+			itemFirstOccurrence,
+			itemLastOccurrence,
+			itemAnomalyScore,
+			itemSpiking,
+		} {
+			deleted += v.DeletePartialMatch(labels)
+		}
+		if HistogramPer == histogramPerItem {
+			deleted += occurenceHistorigram.DeletePartialMatch(labels)
+		}
+		deleted += occurrencesScraped.DeletePartialMatch(labels)
+		occurrencesPerMinute.Replace(labels, nil)
+		limiter.release(item)
+		logrus.Debugf("item %s is stale, %d series deleted", item, deleted)
+	}
+}
+
+var (
+	seriesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "exporter_series_dropped_total",
+		Help: "This is the counter of per-item samples dropped by the series cap",
+	}, []string{
+		"metric",
+	})
+
+	itemSeries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "exporter_item_series",
+		Help: "This is the number of per-item series exposed",
+	})
+)
+
+var (
+	uuidRegex   = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	hexRegex    = regexp.MustCompile(`\b(0x)?[0-9a-fA-F]{8,}\b`)
+	numberRegex = regexp.MustCompile(`\d+`)
+	spaceRegex  = regexp.MustCompile(`\s+`)
+)
+
+// normalizeTitle - replace the variable parts of a title, then truncate it
+func normalizeTitle(title string) string {
+	if TitleNormalize {
+		title = uuidRegex.ReplaceAllString(title, "<uuid>")
+		title = hexRegex.ReplaceAllStringFunc(title, func(s string) string {
+			// a word of letters a-f only is not a hash
+			if !strings.ContainsAny(s, "0123456789") {
+				return s
+			}
+			return "<hex>"
+		})
+		title = numberRegex.ReplaceAllString(title, "<n>")
+		title = strings.TrimSpace(spaceRegex.ReplaceAllString(title, " "))
+	}
+	if TitleMaxLength > 0 {
+		if r := []rune(title); len(r) > TitleMaxLength {
+			title = string(r[:TitleMaxLength]) + "..."
+		}
+	}
+	return title
+}
+
+// topItems - the n items with most occurrences, all of them if n is not positive
+func topItems(totals map[itemEnv]int64, n int) map[int]bool {
+	sums := make(map[int]int64)
+	for key, total := range totals {
+		sums[key.ItemID] += total
+	}
+	ids := make([]int, 0, len(sums))
+	for id := range sums {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if sums[ids[i]] != sums[ids[j]] {
+			return sums[ids[i]] > sums[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if n > 0 && len(ids) > n {
+		ids = ids[:n]
+	}
+	top := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		top[id] = true
+	}
+	return top
+}
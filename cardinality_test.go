@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_TopItems(t *testing.T) {
+	totals := map[itemEnv]int64{
+		{1, "production"}: 5,
+		{1, "staging"}:    5,
+		{2, "production"}: 8,
+		{3, "production"}: 8,
+		{4, "staging"}:    1,
+	}
+	for _, c := range []struct {
+		N   int
+		Top map[int]bool
+	}{
+		{0, map[int]bool{1: true, 2: true, 3: true, 4: true}},
+		{1, map[int]bool{1: true}},
+		{2, map[int]bool{1: true, 2: true}},
+		{3, map[int]bool{1: true, 2: true, 3: true}},
+		{10, map[int]bool{1: true, 2: true, 3: true, 4: true}},
+	} {
+		equals(t, c.Top, topItems(totals, c.N))
+	}
+}
+
+func Test_SeriesLimiter(t *testing.T) {
+	reset(t)
+	defer func(m int) { MaxSeries = m }(MaxSeries)
+	MaxSeries = 2
+
+	l := newSeriesLimiter()
+	item1 := prometheus.Labels{"project_id": "1", "item_id": "1"}
+	item2 := prometheus.Labels{"project_id": "1", "item_id": "2"}
+	assert(t, l.allow("a", item1), "a series under the cap is allowed")
+	assert(t, l.allow("b", item1), "a series under the cap is allowed")
+	assert(t, l.allow("a", item1), "a series seen is always allowed")
+	assert(t, !l.allow("a", item2), "a series over the cap is dropped")
+
+	equals(t, []string{}, l.stale(time.Now().Add(-time.Minute).Unix()))
+	equals(t, []string{"1/1"}, l.stale(time.Now().Add(time.Minute).Unix()))
+
+	l.release("1/1")
+	assert(t, l.allow("a", item2), "a slot released is allowed again")
+}
+
+func Test_SeriesLimiterProjectSeries(t *testing.T) {
+	l := newSeriesLimiter()
+	l.allow("item_occurrences", prometheus.Labels{"project_id": "1", "environment": "production"})
+	equals(t, []string{}, l.stale(time.Now().Add(time.Minute).Unix()))
+}
+
+func Test_ExpireItemSeries(t *testing.T) {
+	reg := reset(t)
+	defer func(m bool) { ItemMetrics = m }(ItemMetrics)
+	ItemMetrics = true
+
+	now := time.Unix(7200, 0)
+	observeOccurrences(1, []rollbar.ItemOccurrence{{ItemID: 1, Environment: "production", OccurrenceCount: 3}}, now.Add(-time.Minute), now)
+	count := func() int {
+		mfs, err := reg.Gather()
+		ok(t, err)
+		n := 0
+		for _, mf := range mfs {
+			if mf.GetName() == metricName("item_occurrences_scraped_total") {
+				n += len(mf.GetMetric())
+			}
+		}
+		return n
+	}
+	equals(t, 1, count())
+
+	expireItemSeries(time.Now())
+	equals(t, 1, count())
+
+	expireItemSeries(time.Now().Add(staleSeriesAfter + time.Minute))
+	equals(t, 0, count())
+	equals(t, 0, len(limiter.seen))
+	equals(t, 0, len(occurrencesScraped.samples()))
+}
+
+func Test_ObserveOccurrencesTracksAllItems(t *testing.T) {
+	reset(t)
+	defer func(top, max int) { TopItems, MaxItemsPerProject = top, max }(TopItems, MaxItemsPerProject)
+	TopItems, MaxItemsPerProject = 1, 0
+
+	now := time.Unix(7200, 0)
+	ids, exposed := observeOccurrences(1, []rollbar.ItemOccurrence{
+		{ItemID: 1, Environment: "production", OccurrenceCount: 3},
+		{ItemID: 2, Environment: "production", OccurrenceCount: 1},
+	}, now.Add(-time.Minute), now)
+	equals(t, []int{1, 2}, ids)
+	equals(t, map[int]bool{1: true}, exposed)
+}
+
+func Test_AllowlistLabels(t *testing.T) {
+	defer func(c, a, v, i, m bool) {
+		ComparisonMetrics, AnomalyDetection, CodeVersionMetrics, ItemMetrics, MinuteResolution = c, a, v, i, m
+	}(ComparisonMetrics, AnomalyDetection, CodeVersionMetrics, ItemMetrics, MinuteResolution)
+	ComparisonMetrics, AnomalyDetection, CodeVersionMetrics, ItemMetrics, MinuteResolution = true, true, true, true, true
+	reset(t)
+
+	// every per-item metric is in allowlistLabels with all of its labels
+	for _, il := range []itemLabels{
+		itemAnomalyScoreLabels, itemSpikingLabels, occurrencesLabels, itemStatusLabels, histogramLabels,
+		lastMinuteLabels, scrapedLabels, perMinuteLabels, firstOccurrenceLabels, lastOccurrenceLabels,
+	} {
+		known, ok := allowlistLabels[il.name]
+		assert(t, ok, "%s is not in allowlistLabels", il.name)
+		for _, label := range il.names {
+			assert(t, identityLabels[label] || contains(known, label), "%s of %s is not in allowlistLabels", label, il.name)
+		}
+	}
+	equals(t, 10, len(allowlistLabels))
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Version - set on build by -ldflags "-X main.Version=..."
+var Version = "dev"
+
+// command - a subcommand of the CLI
+type command struct {
+	Name  string
+	Usage string
+	// Flags - add the flags of the command besides the config ones
+	Flags func(fs *flag.FlagSet)
+	Run   func(args []string) error
+}
+
+// commands - the subcommands, serve if none is given
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"serve", "scrape rollbar and serve the metrics (default)", nil, serveCommand},
+		{"dump", "scrape once and print the metrics", dumpFlags, dumpCommand},
+		{"query", "run an occurrence metrics query of a project and print the rows", queryFlags, queryCommand},
+		{"tokens", "audit the project access tokens, and optionally revoke the ones created by the exporter", tokensFlags, tokensCommand},
+		{"validate", "validate the environment variables, flags and config file", nil, validateCommand},
+		{"version", "print the version", nil, versionCommand},
+	}
+}
+
+// flagValues - environment variable name to the value given by its flag, overrides the config file
+var flagValues = map[string]string{}
+
+// flagName - the flag of an environment variable, e.g. SCRAPE_INTERVAL is --scrape-interval
+func flagName(env string) string {
+	return strings.ReplaceAll(strings.ToLower(env), "_", "-")
+}
+
+// configFile - the config file given by --config or $CONFIG_FILE
+var configFile = ""
+
+// envFlag - the flag of a config environment variable, validated with the rest of the config
+type envFlag struct {
+	name  string
+	field func(c *Config) any
+	// def - the default value, as the environment variable takes it
+	def string
+}
+
+func (f *envFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *envFlag) Set(v string) error {
+	if err := setField(f.field(&Config{}), v); err != nil {
+		return err
+	}
+	flagValues[f.name] = v
+	return nil
+}
+
+// fieldType - the type of a config field shown in the flag usage
+func fieldType(field any) string {
+	switch field.(type) {
+	case *int:
+		return "int"
+	case *float64:
+		return "float"
+	case *bool:
+		return "bool"
+	case *duration:
+		return "duration"
+	case *map[string][]string:
+		return "metric=labels;..."
+	case *map[string]string:
+		return "name=value,..."
+	case *[]SLO:
+		return "file"
+	}
+	return "string"
+}
+
+// formatField - the value of a config field as its environment variable takes it
+func formatField(field any) string {
+	switch f := field.(type) {
+	case *string:
+		return *f
+	case *int:
+		return strconv.Itoa(*f)
+	case *float64:
+		return strconv.FormatFloat(*f, 'g', -1, 64)
+	case *bool:
+		return strconv.FormatBool(*f)
+	case *duration:
+		return time.Duration(*f).String()
+	case *map[string][]string:
+		entries := make([]string, 0, len(*f))
+		for name, labels := range *f {
+			entries = append(entries, name+"="+strings.Join(labels, ","))
+		}
+		sort.Strings(entries)
+		return strings.Join(entries, ";")
+	case *map[string]string:
+		pairs := make([]string, 0, len(*f))
+		for name, value := range *f {
+			pairs = append(pairs, name+"="+value)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	}
+	return ""
+}
+
+// newFlagSet - flags of the command, with a flag for each config environment variable
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\n%s\n\nFlags:\n", os.Args[0], cmd.Name, cmd.Usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&configFile, "config", os.Getenv("CONFIG_FILE"), "config file, same as $CONFIG_FILE")
+	c := currentConfig()
+	for _, e := range envVars {
+		field := e.Field(&c)
+		// the backquoted type is the name of the flag value in the usage
+		usage := fmt.Sprintf("same as $%s, a `%s`", e.Name, fieldType(field))
+		fs.Var(&envFlag{name: e.Name, field: e.Field, def: formatField(field)}, flagName(e.Name), usage)
+	}
+	if cmd.Flags != nil {
+		cmd.Flags(fs)
+	}
+	return fs
+}
+
+// usage - the help of the CLI
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.Name, cmd.Usage)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun '%s [command] -h' for the flags of a command.\n", os.Args[0])
+}
+
+// run - run the command of the args, returns the exit code
+func run(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return 0
+	}
+	var cmd *command
+	for _, c := range commands {
+		if c.Name == name {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(os.Stderr)
+		return 2
+	}
+
+	fs := newFlagSet(cmd)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if err := cmd.Run(fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed - %v\n", cmd.Name, err)
+		return 1
+	}
+	return 0
+}
+
+// configure - load, validate and apply the config
+func configure() error {
+	defaults = currentConfig()
+	c, err := loadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("invalid config - %v", err)
+	}
+	c.apply()
+	config = c
+	if configFile != "" {
+		logrus.Infof("Config from %s", configFile)
+	}
+	if b, err := yaml.Marshal(c); err == nil {
+		logrus.Debugf("config:\n%s", b)
+	}
+	return loadAccounts(c)
+}
+
+func serveCommand(args []string) error {
+	if err := configure(); err != nil {
+		return err
+	}
+	if configFile != "" {
+		go watchConfig(configFile)
+	}
+	go watchSecrets()
+	startScrape()
+	return startHandlers()
+}
+
+func validateCommand(args []string) error {
+	defaults = currentConfig()
+	c, err := loadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("invalid config - %v", err)
+	}
+	if err := loadAccounts(c); err != nil {
+		return err
+	}
+	fmt.Println("config is valid")
+	if c.LogLevel == logrus.DebugLevel.String() || c.LogLevel == logrus.TraceLevel.String() {
+		b, _ := yaml.Marshal(c)
+		fmt.Print(string(b))
+	}
+	return nil
+}
+
+func versionCommand(args []string) error {
+	fmt.Printf("%s %s (%s, %s/%s)\n", filepath.Base(os.Args[0]), Version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" || s.Key == "vcs.time" {
+				fmt.Printf("%s: %s\n", s.Key, s.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// findProject - the project of the ID or name
+func findProject(ref string) (rollbar.Project, error) {
+	ps, err := listProjects()
+	if err != nil {
+		return rollbar.Project{}, err
+	}
+	id, _ := strconv.Atoi(ref)
+	for _, p := range ps {
+		if p.ID == id || p.Name == ref {
+			return p, nil
+		}
+	}
+	return rollbar.Project{}, fmt.Errorf("project %s is not found", ref)
+}
+
+// flags of the query command
+var queryOpts struct {
+	Project     string
+	Window      time.Duration
+	GroupBy     string
+	Granularity string
+	Limit       int
+	Output      string
+}
+
+func queryFlags(fs *flag.FlagSet) {
+	fs.StringVar(&queryOpts.Project, "project", "", "project ID or name, required")
+	fs.DurationVar(&queryOpts.Window, "window", time.Hour, "query the occurrences of this long ago until now")
+	fs.StringVar(&queryOpts.GroupBy, "group-by", "item_id,environment", "fields to group by, comma separated")
+	fs.StringVar(&queryOpts.Granularity, "granularity", "", "bucket the rows by second, minute, hour, day, week, month or year")
+	fs.IntVar(&queryOpts.Limit, "limit", 0, "print at most this many rows if positive")
+	fs.StringVar(&queryOpts.Output, "output", "table", "table or json")
+}
+
+func queryCommand(args []string) error {
+	if queryOpts.Project == "" {
+		return errors.New("-project is required")
+	}
+	if queryOpts.Output != "table" && queryOpts.Output != "json" {
+		return fmt.Errorf("unknown output %q", queryOpts.Output)
+	}
+	if err := configure(); err != nil {
+		return err
+	}
+	// a debug query never changes the account
+	createTokens = false
+
+	p, err := findProject(queryOpts.Project)
+	if err != nil {
+		return err
+	}
+	token, err := projectToken(p)
+	if err != nil {
+		return err
+	}
+
+	fields := make([]rollbar.Field, 0)
+	for _, f := range strings.Split(queryOpts.GroupBy, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, rollbar.Field(f))
+		}
+	}
+	end := time.Now()
+	params := rollbar.NewOccurrencesInputRange(end.Add(-queryOpts.Window), end, fields...)
+	if queryOpts.Granularity != "" {
+		params = params.WithGranularity(rollbar.Granularity(queryOpts.Granularity))
+	}
+	rows, err := accountOf(p.ID).QueryOccurrences(token, params, queryOpts.Limit)
+	if err != nil {
+		return err
+	}
+
+	if queryOpts.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	columns := append([]rollbar.Field{}, fields...)
+	columns = append(columns, rollbar.FieldOccurrenceCount)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := []string{"time"}
+	for _, f := range columns {
+		header = append(header, string(f))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Time.Before(rows[j].Time) })
+	for _, row := range rows {
+		values := []string{row.Time.Format(time.RFC3339)}
+		for _, f := range columns {
+			values = append(values, fmt.Sprint(row.Values[f]))
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_Run(t *testing.T) {
+	restoreConfig(t)
+	flagValues = map[string]string{}
+	t.Setenv("ROLLBAR_ACCOUNT_READ_TOKEN", "x")
+	for _, c := range []struct {
+		Args []string
+		Code int
+	}{
+		{[]string{"help"}, 0},
+		{[]string{"version"}, 0},
+		{[]string{"validate"}, 0},
+		{[]string{"validate", "-h"}, 0},
+		{[]string{"unknown"}, 2},
+		{[]string{"validate", "--unknown-flag"}, 2},
+		{[]string{"validate", "--top-items=many"}, 2},
+		{[]string{"validate", "--top-items=-1"}, 1},
+		{[]string{"query"}, 1},
+		{[]string{"query", "-project=app", "-output=xml"}, 1},
+		{[]string{"dump", "-format=xml"}, 1},
+	} {
+		flagValues = map[string]string{}
+		equals(t, fmt.Sprintf("%v: %d", c.Args, c.Code), fmt.Sprintf("%v: %d", c.Args, run(c.Args)))
+	}
+}
+
+func Test_FlagUsage(t *testing.T) {
+	restoreConfig(t)
+	flagValues = map[string]string{}
+	fs := newFlagSet(&command{Name: "validate"})
+	for _, c := range []struct {
+		Name    string
+		Type    string
+		Default string
+	}{
+		{"scrape-interval", "duration", ScrapeInterval.String()},
+		{"top-items", "int", fmt.Sprint(TopItems)},
+		{"item-metrics", "bool", fmt.Sprint(ItemMetrics)},
+		{"token-name", "string", currentConfig().TokenName},
+		{"const-labels", "name=value,...", ""},
+	} {
+		f := fs.Lookup(c.Name)
+		assert(t, f != nil, "no flag %s", c.Name)
+		name, _ := flag.UnquoteUsage(f)
+		equals(t, c.Type, name)
+		equals(t, c.Default, f.DefValue)
+		assert(t, strings.Contains(f.Usage, "$"+strings.ToUpper(strings.ReplaceAll(c.Name, "-", "_"))), "%s: no environment variable in %q", c.Name, f.Usage)
+	}
+
+	ok(t, fs.Parse([]string{"--top-items=3"}))
+	equals(t, map[string]string{"TOP_ITEMS": "3"}, flagValues)
+	assert(t, fs.Set("top-items", "many") != nil, "an invalid value is rejected")
+}
+
+func Test_QueryCreatesNoToken(t *testing.T) {
+	reset(t)
+	restoreConfig(t)
+	defer func() { createTokens = true }()
+	flagValues = map[string]string{}
+	t.Setenv("ROLLBAR_ACCOUNT_READ_TOKEN", "x")
+	t.Setenv("ROLLBAR_ACCOUNT_WRITE_TOKEN", "y")
+	created := false
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/projects":
+			fmt.Fprint(w, `{"err":0,"result":[{"id":1,"name":"app","status":"enabled"}]}`)
+		case "/project/1/access_tokens":
+			if r.Method == http.MethodPost {
+				created = true
+			}
+			fmt.Fprint(w, `{"err":0,"result":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	equals(t, 1, run([]string{"query", "-project=app"}))
+	assert(t, !created, "query created a project token")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// comparisonOffsets - the windows in the past the current one is compared with, by offset label
+var comparisonOffsets = []struct {
+	Label  string
+	Offset time.Duration
+}{
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+// currentOffset - offset label of the current window
+const currentOffset = "0"
+
+var (
+	comparisonOccurrences = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "project_occurrences_window",
+		Help: "This is the occurrences of a project in the comparison window ending now or offset ago",
+	}, []string{
+		"project_id",
+		"environment",
+		"offset",
+	})
+
+	comparisonRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "project_occurrences_ratio",
+		Help: "This is the occurrences of a project in the comparison window divided by the same window offset ago, absent if nothing occurred then",
+	}, []string{
+		"project_id",
+		"environment",
+		"offset",
+	})
+)
+
+func registerComparisonMetrics() {
+	registry.MustRegister(comparisonOccurrences)
+	registry.MustRegister(comparisonRatio)
+}
+
+// queryComparisons - occurrences per environment of the project in the comparison window ending at end,
+// and in the same window shifted by every offset, keyed by offset label
+func queryComparisons(projectID int, token string, end time.Time) (map[string]map[string]float64, error) {
+	shifts := map[string]time.Duration{currentOffset: 0}
+	for _, o := range comparisonOffsets {
+		shifts[o.Label] = o.Offset
+	}
+	result := make(map[string]map[string]float64, len(shifts))
+	for label, shift := range shifts {
+		to := end.Add(-shift)
+		params := rollbar.NewOccurrencesInputRange(to.Add(-ComparisonWindow), to, rollbar.FieldEnvironment)
+		rows, err := accountOf(projectID).QueryOccurrences(token, params, 0)
+		if err != nil {
+			return nil, err
+		}
+		totals := make(map[string]float64)
+		for _, row := range rows {
+			env := row.String(rollbar.FieldEnvironment)
+			if matchEnvironment(projectID, env) {
+				totals[env] += float64(row.Int(rollbar.FieldOccurrenceCount))
+			}
+		}
+		result[label] = totals
+	}
+	return result, nil
+}
+
+// observeComparisons - update the comparison metrics of the project
+func observeComparisons(projectID int, totals map[string]map[string]float64) {
+	pid := fmt.Sprintf("%d", projectID)
+	comparisonOccurrences.DeletePartialMatch(prometheus.Labels{"project_id": pid})
+	comparisonRatio.DeletePartialMatch(prometheus.Labels{"project_id": pid})
+
+	// an environment occurred in any of the windows is 0 in the others
+	envs := make(map[string]bool)
+	for _, byEnv := range totals {
+		for env := range byEnv {
+			envs[env] = true
+		}
+	}
+	for env := range envs {
+		current := totals[currentOffset][env]
+		comparisonOccurrences.WithLabelValues(pid, env, currentOffset).Set(current)
+		for _, o := range comparisonOffsets {
+			previous := totals[o.Label][env]
+			comparisonOccurrences.WithLabelValues(pid, env, o.Label).Set(previous)
+			if previous > 0 {
+				comparisonRatio.WithLabelValues(pid, env, o.Label).Set(current / previous)
+			}
+		}
+	}
+}
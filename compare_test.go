@@ -0,0 +1,67 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_ObserveComparisons(t *testing.T) {
+	reset(t)
+	comparisonOccurrences.Reset()
+	comparisonRatio.Reset()
+
+	observeComparisons(1, map[string]map[string]float64{
+		currentOffset: {"production": 30, "staging": 4},
+		"1d":          {"production": 10},
+		"7d":          {"production": 60, "qa": 2},
+	})
+	for _, c := range []struct {
+		Environment string
+		Offset      string
+		Occurrences float64
+	}{
+		{"production", currentOffset, 30},
+		{"production", "1d", 10},
+		{"production", "7d", 60},
+		{"staging", currentOffset, 4},
+		{"staging", "1d", 0},
+		{"staging", "7d", 0},
+		{"qa", currentOffset, 0},
+		{"qa", "1d", 0},
+		{"qa", "7d", 2},
+	} {
+		equals(t, c.Occurrences, testutil.ToFloat64(comparisonOccurrences.WithLabelValues("1", c.Environment, c.Offset)))
+	}
+	equals(t, 9, testutil.CollectAndCount(comparisonOccurrences))
+
+	// the ratios are absent when nothing occurred in the past window
+	equals(t, 3.0, testutil.ToFloat64(comparisonRatio.WithLabelValues("1", "production", "1d")))
+	equals(t, 0.5, testutil.ToFloat64(comparisonRatio.WithLabelValues("1", "production", "7d")))
+	equals(t, 0.0, testutil.ToFloat64(comparisonRatio.WithLabelValues("1", "qa", "7d")))
+	comparisonRatio.DeleteLabelValues("1", "qa", "7d")
+	equals(t, 2, testutil.CollectAndCount(comparisonRatio))
+
+	// the environments gone are removed
+	observeComparisons(1, map[string]map[string]float64{currentOffset: {"production": 1}})
+	equals(t, 3, testutil.CollectAndCount(comparisonOccurrences))
+	equals(t, 0, testutil.CollectAndCount(comparisonRatio))
+}
+
+func Test_QueryComparisons(t *testing.T) {
+	reset(t)
+	serve(t, fakeRollbar(`[[{"field":"environment","value":"production"},{"field":"occurrence_count","value":3}],`+
+		`[{"field":"environment","value":"staging"},{"field":"occurrence_count","value":2}]]`))
+	defer func(exclude *regexp.Regexp) { ExcludeEnvironmentsRegex = exclude }(ExcludeEnvironmentsRegex)
+	ExcludeEnvironmentsRegex = regexp.MustCompile("^staging$")
+
+	totals, err := queryComparisons(1, "t", time.Now())
+	ok(t, err)
+	equals(t, map[string]map[string]float64{
+		currentOffset: {"production": 3},
+		"1d":          {"production": 3},
+		"7d":          {"production": 3},
+	}, totals)
+}
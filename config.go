@@ -0,0 +1,689 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// duration - time.Duration in YAML, e.g. 5m
+type duration time.Duration
+
+func (d *duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", value.Line, s)
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+// Config - everything configurable, from the environment variables and the config file.
+// Fields tagged reload:"restart" only take effect on restart.
+type Config struct {
+	LogLevel                 string              `yaml:"log_level"`
+	Port                     int                 `yaml:"port" reload:"restart"`
+	ScrapeInterval           duration            `yaml:"scrape_interval"`
+	MaxItems                 int                 `yaml:"max_items"`
+	IncludeProjectsRegex     string              `yaml:"include_projects_regex"`
+	ExcludeProjectsRegex     string              `yaml:"exclude_projects_regex"`
+	IncludeEnvironmentsRegex string              `yaml:"include_environments_regex"`
+	ExcludeEnvironmentsRegex string              `yaml:"exclude_environments_regex"`
+	ItemMetrics              bool                `yaml:"item_metrics" reload:"restart"`
+	CodeVersionMetrics       bool                `yaml:"code_version_metrics" reload:"restart"`
+	TopItems                 int                 `yaml:"top_items"`
+	MaxSeries                int                 `yaml:"max_series"`
+	TitleMaxLength           int                 `yaml:"title_max_length"`
+	TitleNormalize           bool                `yaml:"title_normalize"`
+	LabelAllowlist           map[string][]string `yaml:"label_allowlist" reload:"restart"`
+	HistogramBuckets         string              `yaml:"histogram_buckets" reload:"restart"`
+	HistogramPer             string              `yaml:"histogram_per" reload:"restart"`
+	NativeHistogramFactor    float64             `yaml:"native_histogram_factor" reload:"restart"`
+	MetricsNamespace         string              `yaml:"metrics_namespace" reload:"restart"`
+	MetricsSubsystem         string              `yaml:"metrics_subsystem" reload:"restart"`
+	LegacyMetricNames        bool                `yaml:"legacy_metric_names" reload:"restart"`
+	ConstLabels              map[string]string   `yaml:"const_labels" reload:"restart"`
+	SLOs                     []SLO               `yaml:"slos"`
+	CustomMetrics            []CustomMetric      `yaml:"custom_metrics" reload:"restart"`
+	ComparisonMetrics        bool                `yaml:"comparison_metrics" reload:"restart"`
+	ComparisonWindow         duration            `yaml:"comparison_window"`
+	AnomalyDetection         bool                `yaml:"anomaly_detection" reload:"restart"`
+	AnomalyAlpha             float64             `yaml:"anomaly_alpha"`
+	AnomalyThreshold         float64             `yaml:"anomaly_threshold"`
+	AnomalyWarmup            int                 `yaml:"anomaly_warmup"`
+	MinuteResolution         bool                `yaml:"minute_resolution" reload:"restart"`
+	StateFile                string              `yaml:"state_file" reload:"restart"`
+	BackfillPeriod           duration            `yaml:"backfill_period"`
+	BackfillWindow           duration            `yaml:"backfill_window"`
+	BackfillOutput           string              `yaml:"backfill_output"`
+	BackfillEndpoint         bool                `yaml:"backfill_endpoint" reload:"restart"`
+	Projects                 []ProjectConfig     `yaml:"projects"`
+	Selection                []SelectionRule     `yaml:"selection"`
+	Accounts                 []AccountConfig     `yaml:"accounts" reload:"restart"`
+	ReadOnly                 bool                `yaml:"read_only" reload:"restart"`
+	ProjectTokensFile        string              `yaml:"project_tokens_file" reload:"restart"`
+	TokenName                string              `yaml:"token_name"`
+	TokenMarker              string              `yaml:"token_marker"`
+	TokenRateLimitSize       int                 `yaml:"token_rate_limit_window_size"`
+	TokenRateLimitCount      int                 `yaml:"token_rate_limit_window_count"`
+	TokenReuseOthers         bool                `yaml:"token_reuse_others"`
+
+	// compiled by validate
+	includeProjects     *regexp.Regexp
+	excludeProjects     *regexp.Regexp
+	includeEnvironments *regexp.Regexp
+	excludeEnvironments *regexp.Regexp
+	buckets             []float64
+}
+
+// ProjectConfig - overrides of the settings for a project, matched by ID or name
+type ProjectConfig struct {
+	ID                       int     `yaml:"id"`
+	Name                     string  `yaml:"name"`
+	Skip                     bool    `yaml:"skip"`
+	MaxItems                 *int    `yaml:"max_items"`
+	TopItems                 *int    `yaml:"top_items"`
+	IncludeEnvironmentsRegex *string `yaml:"include_environments_regex"`
+	ExcludeEnvironmentsRegex *string `yaml:"exclude_environments_regex"`
+
+	// compiled by validate
+	includeEnvironments *regexp.Regexp
+	excludeEnvironments *regexp.Regexp
+}
+
+// envVars - the environment variables of the config fields
+var envVars = []struct {
+	Name  string
+	Field func(c *Config) any
+}{
+	{"LOG_LEVEL", func(c *Config) any { return &c.LogLevel }},
+	{"INCLUDE_PROJECTS_REGEX", func(c *Config) any { return &c.IncludeProjectsRegex }},
+	{"EXCLUDE_PROJECTS_REGEX", func(c *Config) any { return &c.ExcludeProjectsRegex }},
+	{"INCLUDE_ENVIRONMENTS_REGEX", func(c *Config) any { return &c.IncludeEnvironmentsRegex }},
+	{"EXCLUDE_ENVIRONMENTS_REGEX", func(c *Config) any { return &c.ExcludeEnvironmentsRegex }},
+	{"PORT", func(c *Config) any { return &c.Port }},
+	{"SCRAPE_INTERVAL", func(c *Config) any { return &c.ScrapeInterval }},
+	{"MAX_ITEMS", func(c *Config) any { return &c.MaxItems }},
+	{"ITEM_METRICS", func(c *Config) any { return &c.ItemMetrics }},
+	{"CODE_VERSION_METRICS", func(c *Config) any { return &c.CodeVersionMetrics }},
+	{"TOP_ITEMS", func(c *Config) any { return &c.TopItems }},
+	{"MAX_SERIES", func(c *Config) any { return &c.MaxSeries }},
+	{"TITLE_MAX_LENGTH", func(c *Config) any { return &c.TitleMaxLength }},
+	{"TITLE_NORMALIZE", func(c *Config) any { return &c.TitleNormalize }},
+	{"LABEL_ALLOWLIST", func(c *Config) any { return &c.LabelAllowlist }},
+	{"HISTOGRAM_BUCKETS", func(c *Config) any { return &c.HistogramBuckets }},
+	{"HISTOGRAM_PER", func(c *Config) any { return &c.HistogramPer }},
+	{"NATIVE_HISTOGRAM_FACTOR", func(c *Config) any { return &c.NativeHistogramFactor }},
+	{"METRICS_NAMESPACE", func(c *Config) any { return &c.MetricsNamespace }},
+	{"METRICS_SUBSYSTEM", func(c *Config) any { return &c.MetricsSubsystem }},
+	{"LEGACY_METRIC_NAMES", func(c *Config) any { return &c.LegacyMetricNames }},
+	{"CONST_LABELS", func(c *Config) any { return &c.ConstLabels }},
+	{"SLO_CONFIG", func(c *Config) any { return &c.SLOs }},
+	{"COMPARISON_METRICS", func(c *Config) any { return &c.ComparisonMetrics }},
+	{"COMPARISON_WINDOW", func(c *Config) any { return &c.ComparisonWindow }},
+	{"ANOMALY_DETECTION", func(c *Config) any { return &c.AnomalyDetection }},
+	{"ANOMALY_ALPHA", func(c *Config) any { return &c.AnomalyAlpha }},
+	{"ANOMALY_THRESHOLD", func(c *Config) any { return &c.AnomalyThreshold }},
+	{"ANOMALY_WARMUP", func(c *Config) any { return &c.AnomalyWarmup }},
+	{"MINUTE_RESOLUTION", func(c *Config) any { return &c.MinuteResolution }},
+	{"STATE_FILE", func(c *Config) any { return &c.StateFile }},
+	{"BACKFILL_PERIOD", func(c *Config) any { return &c.BackfillPeriod }},
+	{"BACKFILL_WINDOW", func(c *Config) any { return &c.BackfillWindow }},
+	{"BACKFILL_OUTPUT", func(c *Config) any { return &c.BackfillOutput }},
+	{"BACKFILL_ENDPOINT", func(c *Config) any { return &c.BackfillEndpoint }},
+	{"READ_ONLY", func(c *Config) any { return &c.ReadOnly }},
+	{"PROJECT_TOKENS_FILE", func(c *Config) any { return &c.ProjectTokensFile }},
+	{"TOKEN_NAME", func(c *Config) any { return &c.TokenName }},
+	{"TOKEN_MARKER", func(c *Config) any { return &c.TokenMarker }},
+	{"TOKEN_RATE_LIMIT_WINDOW_SIZE", func(c *Config) any { return &c.TokenRateLimitSize }},
+	{"TOKEN_RATE_LIMIT_WINDOW_COUNT", func(c *Config) any { return &c.TokenRateLimitCount }},
+	{"TOKEN_REUSE_OTHERS", func(c *Config) any { return &c.TokenReuseOthers }},
+}
+
+// setField - parse the value of an environment variable into the field
+func setField(field any, v string) error {
+	switch f := field.(type) {
+	case *string:
+		*f = v
+	case *int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		*f = n
+	case *float64:
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		*f = n
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*f = b
+	case *duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+		*f = duration(d)
+	case *map[string][]string:
+		allowlist, err := parseLabelAllowlist(v)
+		if err != nil {
+			return err
+		}
+		*f = allowlist
+	case *map[string]string:
+		labels, err := parseConstLabels(v)
+		if err != nil {
+			return err
+		}
+		*f = labels
+	case *[]SLO:
+		slos, err := loadSLOs(v)
+		if err != nil {
+			return err
+		}
+		*f = slos
+	default:
+		return fmt.Errorf("unsupported field type %T", field)
+	}
+	return nil
+}
+
+// currentConfig - the config of the settings in effect
+func currentConfig() Config {
+	buckets := make([]string, 0, len(HistogramBuckets))
+	for _, b := range HistogramBuckets {
+		buckets = append(buckets, strconv.FormatFloat(b, 'g', -1, 64))
+	}
+	c := Config{
+		LogLevel:                 logrus.GetLevel().String(),
+		Port:                     Port,
+		ScrapeInterval:           duration(ScrapeInterval),
+		MaxItems:                 MaxItemsPerProject,
+		IncludeProjectsRegex:     IncludeProjectsRegex.String(),
+		ExcludeProjectsRegex:     ExcludeProjectsRegex.String(),
+		IncludeEnvironmentsRegex: IncludeEnvironmentsRegex.String(),
+		ExcludeEnvironmentsRegex: ExcludeEnvironmentsRegex.String(),
+		ItemMetrics:              ItemMetrics,
+		CodeVersionMetrics:       CodeVersionMetrics,
+		TopItems:                 TopItems,
+		MaxSeries:                MaxSeries,
+		TitleMaxLength:           TitleMaxLength,
+		TitleNormalize:           TitleNormalize,
+		LabelAllowlist:           LabelAllowlist,
+		HistogramBuckets:         strings.Join(buckets, ","),
+		HistogramPer:             HistogramPer,
+		NativeHistogramFactor:    NativeHistogramFactor,
+		MetricsNamespace:         MetricsNamespace,
+		MetricsSubsystem:         MetricsSubsystem,
+		LegacyMetricNames:        LegacyMetricNames,
+		ConstLabels:              ConstLabels,
+		SLOs:                     SLOs,
+		CustomMetrics:            CustomMetrics,
+		ComparisonMetrics:        ComparisonMetrics,
+		ComparisonWindow:         duration(ComparisonWindow),
+		AnomalyDetection:         AnomalyDetection,
+		AnomalyAlpha:             AnomalyAlpha,
+		AnomalyThreshold:         AnomalyThreshold,
+		AnomalyWarmup:            AnomalyWarmup,
+		MinuteResolution:         MinuteResolution,
+		StateFile:                StateFile,
+		BackfillPeriod:           duration(BackfillPeriod),
+		BackfillWindow:           duration(BackfillWindow),
+		BackfillOutput:           BackfillOutput,
+		BackfillEndpoint:         BackfillEndpoint,
+		Projects:                 ProjectOverrides,
+		Selection:                SelectionRules,
+		Accounts:                 Accounts,
+		ReadOnly:                 ReadOnly,
+		ProjectTokensFile:        ProjectTokensFile,
+		TokenName:                rollbar.ExporterToken.Name,
+		TokenMarker:              rollbar.ExporterToken.Marker,
+		TokenRateLimitSize:       rollbar.ExporterToken.RateLimitWindowSize,
+		TokenRateLimitCount:      rollbar.ExporterToken.RateLimitWindowCount,
+		TokenReuseOthers:         rollbar.ExporterToken.ReuseOthers,
+	}
+	return c.clone()
+}
+
+// clone - a copy sharing no map or slice with c
+func (c Config) clone() Config {
+	allowlist := make(map[string][]string, len(c.LabelAllowlist))
+	for name, labels := range c.LabelAllowlist {
+		allowlist[name] = append([]string{}, labels...)
+	}
+	c.LabelAllowlist = allowlist
+	constLabels := make(map[string]string, len(c.ConstLabels))
+	for name, value := range c.ConstLabels {
+		constLabels[name] = value
+	}
+	c.ConstLabels = constLabels
+	c.SLOs = append([]SLO{}, c.SLOs...)
+	c.CustomMetrics = append([]CustomMetric{}, c.CustomMetrics...)
+	c.Projects = append([]ProjectConfig{}, c.Projects...)
+	c.Selection = append([]SelectionRule{}, c.Selection...)
+	c.Accounts = append([]AccountConfig{}, c.Accounts...)
+	return c
+}
+
+// defaults - the config before any environment variable or config file, set by main
+var defaults Config
+
+// loadConfig - the defaults overridden by the environment variables, the config file if path is set, then the flags
+func loadConfig(path string) (Config, error) {
+	c := defaults.clone()
+	if err := c.loadEnv(); err != nil {
+		return c, err
+	}
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			return c, err
+		}
+	}
+	// the flags win over the config file
+	for _, e := range envVars {
+		if v, ok := flagValues[e.Name]; ok {
+			if err := setField(e.Field(&c), v); err != nil {
+				return c, fmt.Errorf("--%s: %v", flagName(e.Name), err)
+			}
+		}
+	}
+	if err := c.validate(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// loadEnv - override the fields set by environment variables, empty ones are ignored
+func (c *Config) loadEnv() error {
+	for _, e := range envVars {
+		v, ok := os.LookupEnv(e.Name)
+		if !ok || v == "" {
+			continue
+		}
+		if err := setField(e.Field(c), v); err != nil {
+			return fmt.Errorf("$%s: %v", e.Name, err)
+		}
+		logrus.Debugf("$%s: %s", e.Name, v)
+	}
+	return nil
+}
+
+// loadFile - override the fields set in the YAML file, unknown fields are errors
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+var nameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validate - check the fields and compile the regexes and buckets
+func (c *Config) validate() error {
+	var err error
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		return fmt.Errorf("log_level: invalid level %q", c.LogLevel)
+	}
+	if c.Port <= 1024 || c.Port > 65535 {
+		return fmt.Errorf("port: %d should be between 1025 and 65535", c.Port)
+	}
+	if c.ScrapeInterval < duration(time.Minute) {
+		return fmt.Errorf("scrape_interval: %s should be at least 1m", time.Duration(c.ScrapeInterval))
+	}
+	for name, n := range map[string]int{
+		"max_items":                     c.MaxItems,
+		"top_items":                     c.TopItems,
+		"max_series":                    c.MaxSeries,
+		"title_max_length":              c.TitleMaxLength,
+		"anomaly_warmup":                c.AnomalyWarmup,
+		"token_rate_limit_window_size":  c.TokenRateLimitSize,
+		"token_rate_limit_window_count": c.TokenRateLimitCount,
+	} {
+		if n < 0 {
+			return fmt.Errorf("%s: %d should not be negative", name, n)
+		}
+	}
+	if c.includeProjects, err = compileRegex("include_projects_regex", c.IncludeProjectsRegex); err != nil {
+		return err
+	}
+	if c.excludeProjects, err = compileRegex("exclude_projects_regex", c.ExcludeProjectsRegex); err != nil {
+		return err
+	}
+	if c.includeEnvironments, err = compileRegex("include_environments_regex", c.IncludeEnvironmentsRegex); err != nil {
+		return err
+	}
+	if c.excludeEnvironments, err = compileRegex("exclude_environments_regex", c.ExcludeEnvironmentsRegex); err != nil {
+		return err
+	}
+	c.buckets = defaultBuckets
+	if c.HistogramBuckets != "" {
+		if c.buckets, err = parseBuckets(c.HistogramBuckets); err != nil {
+			return fmt.Errorf("histogram_buckets: %v", err)
+		}
+	}
+	if c.HistogramPer != histogramPerItem && c.HistogramPer != histogramPerProject {
+		return fmt.Errorf("histogram_per: %q should be %s or %s", c.HistogramPer, histogramPerItem, histogramPerProject)
+	}
+	if c.NativeHistogramFactor != 0 && c.NativeHistogramFactor <= 1 {
+		return fmt.Errorf("native_histogram_factor: %g should be greater than 1", c.NativeHistogramFactor)
+	}
+	for name, v := range map[string]string{
+		"metrics_namespace": c.MetricsNamespace,
+		"metrics_subsystem": c.MetricsSubsystem,
+	} {
+		if v != "" && !nameRegex.MatchString(v) {
+			return fmt.Errorf("%s: %q is not a valid metric name", name, v)
+		}
+	}
+	for name := range c.ConstLabels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("const_labels: %q is not a valid label name", name)
+		}
+		if builtinLabels[name] {
+			return fmt.Errorf("const_labels: %q is a label of the metrics", name)
+		}
+	}
+	if err := validateLabelAllowlist(c.LabelAllowlist); err != nil {
+		return err
+	}
+	if err := validateSLOs(c.SLOs); err != nil {
+		return err
+	}
+	if err := validateCustomMetrics(c.CustomMetrics, c.ConstLabels); err != nil {
+		return err
+	}
+	if err := validateSelectionRules(c.Selection); err != nil {
+		return err
+	}
+	if err := validateAccounts(c.Accounts); err != nil {
+		return err
+	}
+	if _, ok := c.ConstLabels[accountLabel]; ok && len(c.Accounts) > 0 {
+		return fmt.Errorf("const_labels: %s is the label of the accounts", accountLabel)
+	}
+	if c.ComparisonWindow < duration(time.Minute) {
+		return fmt.Errorf("comparison_window: %s should be at least 1m", time.Duration(c.ComparisonWindow))
+	}
+	if c.AnomalyAlpha <= 0 || c.AnomalyAlpha > 1 {
+		return fmt.Errorf("anomaly_alpha: %g should be in (0, 1]", c.AnomalyAlpha)
+	}
+	if c.AnomalyThreshold <= 0 {
+		return fmt.Errorf("anomaly_threshold: %g should be positive", c.AnomalyThreshold)
+	}
+	if c.TokenName == "" {
+		return fmt.Errorf("token_name: should not be empty")
+	}
+	if (c.TokenRateLimitSize == 0) != (c.TokenRateLimitCount == 0) {
+		return fmt.Errorf("token_rate_limit_window_size and token_rate_limit_window_count should be set together")
+	}
+	if c.BackfillPeriod != 0 && c.BackfillPeriod < duration(time.Minute) {
+		return fmt.Errorf("backfill_period: %s should be at least 1m", time.Duration(c.BackfillPeriod))
+	}
+	if c.BackfillPeriod > duration(maxBackfillPeriod) {
+		return fmt.Errorf("backfill_period: %s should be at most %s", time.Duration(c.BackfillPeriod), maxBackfillPeriod)
+	}
+	if c.BackfillWindow < duration(time.Minute) {
+		return fmt.Errorf("backfill_window: %s should be at least 1m", time.Duration(c.BackfillWindow))
+	}
+	for i := range c.Projects {
+		p := &c.Projects[i]
+		if p.ID == 0 && p.Name == "" {
+			return fmt.Errorf("projects[%d]: id or name is required", i)
+		}
+		if p.MaxItems != nil && *p.MaxItems < 0 {
+			return fmt.Errorf("projects[%d]: max_items %d should not be negative", i, *p.MaxItems)
+		}
+		if p.TopItems != nil && *p.TopItems < 0 {
+			return fmt.Errorf("projects[%d]: top_items %d should not be negative", i, *p.TopItems)
+		}
+		if p.IncludeEnvironmentsRegex != nil {
+			if p.includeEnvironments, err = compileRegex(fmt.Sprintf("projects[%d]: include_environments_regex", i), *p.IncludeEnvironmentsRegex); err != nil {
+				return err
+			}
+		}
+		if p.ExcludeEnvironmentsRegex != nil {
+			if p.excludeEnvironments, err = compileRegex(fmt.Sprintf("projects[%d]: exclude_environments_regex", i), *p.ExcludeEnvironmentsRegex); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func compileRegex(name, expr string) (*regexp.Regexp, error) {
+	r, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return r, nil
+}
+
+// apply - put the validated config in effect
+func (c Config) apply() {
+	if l, err := logrus.ParseLevel(c.LogLevel); err == nil {
+		logrus.SetLevel(l)
+	}
+	Port = c.Port
+	ScrapeInterval = time.Duration(c.ScrapeInterval)
+	MaxItemsPerProject = c.MaxItems
+	IncludeProjectsRegex = c.includeProjects
+	ExcludeProjectsRegex = c.excludeProjects
+	IncludeEnvironmentsRegex = c.includeEnvironments
+	ExcludeEnvironmentsRegex = c.excludeEnvironments
+	ItemMetrics = c.ItemMetrics
+	CodeVersionMetrics = c.CodeVersionMetrics
+	TopItems = c.TopItems
+	MaxSeries = c.MaxSeries
+	TitleMaxLength = c.TitleMaxLength
+	TitleNormalize = c.TitleNormalize
+	LabelAllowlist = c.LabelAllowlist
+	HistogramBuckets = c.buckets
+	HistogramPer = c.HistogramPer
+	NativeHistogramFactor = c.NativeHistogramFactor
+	MetricsNamespace = c.MetricsNamespace
+	MetricsSubsystem = c.MetricsSubsystem
+	LegacyMetricNames = c.LegacyMetricNames
+	ConstLabels = prometheus.Labels(c.ConstLabels)
+	SLOs = c.SLOs
+	CustomMetrics = c.CustomMetrics
+	ComparisonMetrics = c.ComparisonMetrics
+	ComparisonWindow = time.Duration(c.ComparisonWindow)
+	AnomalyDetection = c.AnomalyDetection
+	AnomalyAlpha = c.AnomalyAlpha
+	AnomalyThreshold = c.AnomalyThreshold
+	AnomalyWarmup = c.AnomalyWarmup
+	MinuteResolution = c.MinuteResolution
+	StateFile = c.StateFile
+	BackfillPeriod = time.Duration(c.BackfillPeriod)
+	BackfillWindow = time.Duration(c.BackfillWindow)
+	BackfillOutput = c.BackfillOutput
+	BackfillEndpoint = c.BackfillEndpoint
+	ProjectOverrides = c.Projects
+	SelectionRules = c.Selection
+	Accounts = c.Accounts
+	ReadOnly = c.ReadOnly
+	ProjectTokensFile = c.ProjectTokensFile
+	rollbar.ExporterToken = rollbar.TokenOptions{
+		Name:                 c.TokenName,
+		Marker:               c.TokenMarker,
+		RateLimitWindowSize:  c.TokenRateLimitSize,
+		RateLimitWindowCount: c.TokenRateLimitCount,
+		ReuseOthers:          c.TokenReuseOthers,
+	}
+}
+
+// keepRestartFields - copy the fields only applied on restart from old, returns their YAML names if they differ
+func (c *Config) keepRestartFields(old Config) []string {
+	changed := make([]string, 0)
+	v, o := reflect.ValueOf(c).Elem(), reflect.ValueOf(old)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Tag.Get("reload") != "restart" {
+			continue
+		}
+		if !reflect.DeepEqual(v.Field(i).Interface(), o.Field(i).Interface()) {
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			changed = append(changed, name)
+			v.Field(i).Set(o.Field(i))
+		}
+	}
+	return changed
+}
+
+// configPollInterval - how often the config file is checked for changes
+const configPollInterval = 30 * time.Second
+
+var (
+	// config - the config in effect
+	config Config
+	// reloads - validated configs waiting to be applied between scrape cycles
+	reloads = make(chan Config, 1)
+)
+
+// watchConfig - reload the config file on SIGHUP or when its content changes
+func watchConfig(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	last, _ := os.ReadFile(path)
+	for {
+		select {
+		case <-hup:
+			logrus.Infof("SIGHUP received, reload config %s", path)
+		case <-ticker.C:
+			b, err := os.ReadFile(path)
+			if err != nil || bytes.Equal(b, last) {
+				continue
+			}
+			logrus.Infof("config %s changed, reload", path)
+		}
+		last, _ = os.ReadFile(path)
+		c, err := loadConfig(path)
+		if err != nil {
+			logrus.Errorf("reload config failed, keep the current one - %v", err)
+			configReloads.WithLabelValues("failure").Inc()
+			continue
+		}
+		// only the latest config waits to be applied
+		select {
+		case <-reloads:
+		default:
+		}
+		reloads <- c
+	}
+}
+
+// applyReload - put a reloaded config in effect between cycles, the scrape state is kept
+func applyReload(c Config) {
+	cycle.Lock()
+	defer cycle.Unlock()
+
+	if changed := c.keepRestartFields(config); len(changed) > 0 {
+		logrus.Warnf("config %s changed, restart to apply", strings.Join(changed, ", "))
+		// compile the kept fields again
+		if err := c.validate(); err != nil {
+			logrus.Errorf("reload config failed, keep the current one - %v", err)
+			configReloads.WithLabelValues("failure").Inc()
+			return
+		}
+	}
+	if !reflect.DeepEqual(c.SLOs, config.SLOs) {
+		sloObjective.Reset()
+		sloOccurrences.Reset()
+		sloBudgetRemaining.Reset()
+		sloBurnRate.Reset()
+	}
+	c.apply()
+	config = c
+	configReloads.WithLabelValues("success").Inc()
+	logrus.Infof("config reloaded")
+}
+
+// projectSettings - the settings of a project, the global ones unless overridden
+type projectSettings struct {
+	Skip                bool
+	MaxItems            int
+	TopItems            int
+	IncludeEnvironments *regexp.Regexp
+	ExcludeEnvironments *regexp.Regexp
+}
+
+// overrides - project ID to its overrides, resolved by resolveOverrides every cycle
+var overrides = map[int]ProjectConfig{}
+
+// resolveOverrides - match the project overrides by ID or name, the first match wins
+func resolveOverrides(ps []rollbar.Project) {
+	overrides = make(map[int]ProjectConfig)
+	for _, p := range ps {
+		for _, o := range ProjectOverrides {
+			if (o.ID != 0 && o.ID == p.ID) || (o.Name != "" && o.Name == p.Name) {
+				overrides[p.ID] = o
+				break
+			}
+		}
+	}
+}
+
+// settingsOf - the settings of the project
+func settingsOf(projectID int) projectSettings {
+	s := projectSettings{
+		MaxItems:            MaxItemsPerProject,
+		TopItems:            TopItems,
+		IncludeEnvironments: IncludeEnvironmentsRegex,
+		ExcludeEnvironments: ExcludeEnvironmentsRegex,
+	}
+	o, ok := overrides[projectID]
+	if !ok {
+		return s
+	}
+	s.Skip = o.Skip
+	if o.MaxItems != nil {
+		s.MaxItems = *o.MaxItems
+	}
+	if o.TopItems != nil {
+		s.TopItems = *o.TopItems
+	}
+	if o.includeEnvironments != nil {
+		s.IncludeEnvironments = o.includeEnvironments
+	}
+	if o.excludeEnvironments != nil {
+		s.ExcludeEnvironments = o.excludeEnvironments
+	}
+	return s
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// restoreConfig - put the config in effect back at the end of the test
+func restoreConfig(tb testing.TB) {
+	old := currentConfig()
+	oldDefaults, oldFlags := defaults, flagValues
+	tb.Cleanup(func() {
+		if err := old.validate(); err != nil {
+			tb.Fatalf("restore config failed - %v", err)
+		}
+		old.apply()
+		defaults, flagValues = oldDefaults, oldFlags
+	})
+}
+
+func Test_ValidateConfig(t *testing.T) {
+	restoreConfig(t)
+	negative := -1
+	for _, c := range []struct {
+		Name   string
+		Modify func(c *Config)
+		Error  string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"log level", func(c *Config) { c.LogLevel = "loud" }, "log_level:"},
+		{"low port", func(c *Config) { c.Port = 80 }, "port:"},
+		{"short interval", func(c *Config) { c.ScrapeInterval = duration(time.Second) }, "scrape_interval:"},
+		{"negative max items", func(c *Config) { c.MaxItems = -1 }, "max_items:"},
+		{"negative top items", func(c *Config) { c.TopItems = -1 }, "top_items:"},
+		{"project regex", func(c *Config) { c.IncludeProjectsRegex = "(" }, "include_projects_regex:"},
+		{"environment regex", func(c *Config) { c.ExcludeEnvironmentsRegex = "(" }, "exclude_environments_regex:"},
+		{"buckets", func(c *Config) { c.HistogramBuckets = "1,1" }, "histogram_buckets:"},
+		{"histogram per", func(c *Config) { c.HistogramPer = "account" }, "histogram_per:"},
+		{"native histogram", func(c *Config) { c.NativeHistogramFactor = 1 }, "native_histogram_factor:"},
+		{"namespace", func(c *Config) { c.MetricsNamespace = "roll-bar" }, "metrics_namespace:"},
+		{"const label name", func(c *Config) { c.ConstLabels = map[string]string{"a-b": "c"} }, "const_labels:"},
+		{"const label of the metrics", func(c *Config) { c.ConstLabels = map[string]string{"environment": "prod"} }, "const_labels:"},
+		{"const label", func(c *Config) { c.ConstLabels = map[string]string{"cluster": "prod"} }, ""},
+		{"allowlist metric", func(c *Config) { c.LabelAllowlist = map[string][]string{"item_statuz": {"level"}} }, `label_allowlist: "item_statuz"`},
+		{"allowlist label", func(c *Config) { c.LabelAllowlist = map[string][]string{"item_status": {"levl"}} }, `label_allowlist: "levl"`},
+		{"allowlist", func(c *Config) {
+			c.LabelAllowlist = map[string][]string{"item_status": {"project_id", "level"}, "item_occurrences": {}}
+		}, ""},
+		{"comparison window", func(c *Config) { c.ComparisonWindow = duration(time.Second) }, "comparison_window:"},
+		{"anomaly alpha", func(c *Config) { c.AnomalyAlpha = 2 }, "anomaly_alpha:"},
+		{"anomaly threshold", func(c *Config) { c.AnomalyThreshold = 0 }, "anomaly_threshold:"},
+		{"token name", func(c *Config) { c.TokenName = "" }, "token_name:"},
+		{"token rate limit", func(c *Config) { c.TokenRateLimitSize = 60 }, "token_rate_limit_window_size"},
+		{"short backfill", func(c *Config) { c.BackfillPeriod = duration(time.Second) }, "backfill_period:"},
+		{"long backfill", func(c *Config) { c.BackfillPeriod = duration(maxBackfillPeriod + time.Hour) }, "backfill_period:"},
+		{"backfill window", func(c *Config) { c.BackfillWindow = 0 }, "backfill_window:"},
+		{"project reference", func(c *Config) { c.Projects = []ProjectConfig{{}} }, "projects[0]: id or name"},
+		{"project max items", func(c *Config) { c.Projects = []ProjectConfig{{ID: 1, MaxItems: &negative}} }, "projects[0]: max_items"},
+	} {
+		cfg := currentConfig()
+		c.Modify(&cfg)
+		err := cfg.validate()
+		if c.Error == "" {
+			assert(t, err == nil, "%s: unexpected error %v", c.Name, err)
+			continue
+		}
+		assert(t, err != nil && strings.Contains(err.Error(), c.Error), "%s: expected %q, got %v", c.Name, c.Error, err)
+	}
+}
+
+func Test_ParseConstLabels(t *testing.T) {
+	for _, c := range []struct {
+		Value  string
+		Labels map[string]string
+		Error  string
+	}{
+		{"", map[string]string{}, ""},
+		{"cluster=prod", map[string]string{"cluster": "prod"}, ""},
+		{" cluster = prod , region=eu,", map[string]string{"cluster": "prod", "region": "eu"}, ""},
+		{"cluster=", map[string]string{"cluster": ""}, ""},
+		{"cluster", nil, `"cluster" should be name=value`},
+		{"cluster=prod,region", nil, `"region" should be name=value`},
+		{"=prod", nil, `"=prod" should be name=value`},
+	} {
+		labels, err := parseConstLabels(c.Value)
+		if c.Error != "" {
+			assert(t, err != nil && err.Error() == c.Error, "%q: expected %q, got %v", c.Value, c.Error, err)
+			continue
+		}
+		ok(t, err)
+		equals(t, c.Labels, map[string]string(labels))
+	}
+}
+
+func Test_ParseLabelAllowlist(t *testing.T) {
+	for _, c := range []struct {
+		Value     string
+		Allowlist map[string][]string
+		Error     string
+	}{
+		{"", map[string][]string{}, ""},
+		{"item_status=status,level", map[string][]string{"item_status": {"status", "level"}}, ""},
+		{" item_status = level ; item_occurrences= ;", map[string][]string{"item_status": {"level"}, "item_occurrences": {}}, ""},
+		{"item_status", nil, `"item_status" should be metric=label,label`},
+		{"item_status=level;=level", nil, `"=level" should be metric=label,label`},
+	} {
+		allowlist, err := parseLabelAllowlist(c.Value)
+		if c.Error != "" {
+			assert(t, err != nil && err.Error() == c.Error, "%q: expected %q, got %v", c.Value, c.Error, err)
+			continue
+		}
+		ok(t, err)
+		equals(t, c.Allowlist, allowlist)
+	}
+
+	// the environment variables name the bad entry
+	restoreConfig(t)
+	defaults = currentConfig()
+	t.Setenv("CONST_LABELS", "cluster")
+	_, err := loadConfig("")
+	assert(t, err != nil && err.Error() == `$CONST_LABELS: "cluster" should be name=value`, "got %v", err)
+	t.Setenv("CONST_LABELS", "")
+	t.Setenv("LABEL_ALLOWLIST", "item_status=levl")
+	_, err = loadConfig("")
+	assert(t, err != nil && err.Error() == `label_allowlist: "levl" is not a label of item_status`, "got %v", err)
+}
+
+func Test_LoadConfig(t *testing.T) {
+	restoreConfig(t)
+	defaults = currentConfig()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	ok(t, os.WriteFile(path, []byte("top_items: 20\nmax_items: 300\n"), 0o600))
+
+	// the environment variables are overridden by the file, then by the flags
+	t.Setenv("TOP_ITEMS", "5")
+	t.Setenv("TITLE_MAX_LENGTH", "40")
+	flagValues = map[string]string{"MAX_ITEMS": "100"}
+	c, err := loadConfig(path)
+	ok(t, err)
+	equals(t, 20, c.TopItems)
+	equals(t, 40, c.TitleMaxLength)
+	equals(t, 100, c.MaxItems)
+
+	flagValues = map[string]string{"MAX_ITEMS": "many"}
+	_, err = loadConfig(path)
+	assert(t, err != nil && strings.HasPrefix(err.Error(), "--max-items:"), "an invalid flag is rejected, got %v", err)
+
+	flagValues = map[string]string{}
+	ok(t, os.WriteFile(path, []byte("top_itemz: 20\n"), 0o600))
+	_, err = loadConfig(path)
+	assert(t, err != nil, "an unknown field is rejected")
+
+	t.Setenv("TOP_ITEMS", "-5")
+	ok(t, os.WriteFile(path, []byte(""), 0o600))
+	_, err = loadConfig(path)
+	assert(t, err != nil && strings.HasPrefix(err.Error(), "top_items:"), "an invalid config is rejected, got %v", err)
+}
+
+func Test_KeepRestartFields(t *testing.T) {
+	old := currentConfig()
+	c := currentConfig()
+	c.Port = old.Port + 1
+	c.ItemMetrics = !old.ItemMetrics
+	c.TopItems = old.TopItems + 1
+
+	equals(t, []string{"port", "item_metrics"}, c.keepRestartFields(old))
+	equals(t, old.Port, c.Port)
+	equals(t, old.ItemMetrics, c.ItemMetrics)
+	equals(t, old.TopItems+1, c.TopItems)
+	equals(t, []string{}, c.keepRestartFields(old))
+}
+
+func Test_ApplyReload(t *testing.T) {
+	reset(t)
+	restoreConfig(t)
+	old := currentConfig()
+	ok(t, old.validate())
+	config = old
+
+	c := currentConfig()
+	c.Port = old.Port + 1
+	c.TopItems = old.TopItems + 7
+	c.SLOs = []SLO{{Name: "checkout", ProjectIDs: []int{1}, Window: duration(time.Hour), Objective: 1}}
+	ok(t, c.validate())
+	sloObjective.WithLabelValues("gone").Set(1)
+
+	applyReload(c)
+	equals(t, old.Port, Port)
+	equals(t, old.TopItems+7, TopItems)
+	equals(t, old.Port, config.Port)
+	equals(t, c.SLOs, SLOs)
+	// the series of the SLOs changed are removed
+	equals(t, 0, testutil.CollectAndCount(sloObjective))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// persistentCounter - counter vector which remembers its values, so they
+// can be saved to the state and restored after restart
+type persistentCounter struct {
+	*prometheus.CounterVec
+	name       string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]*state.Sample
+}
+
+// persistentCounters - all the counters saved to the state, by name
+var persistentCounters = make(map[string]*persistentCounter)
+
+func newPersistentCounter(opts prometheus.CounterOpts, labelNames []string) *persistentCounter {
+	c := &persistentCounter{
+		CounterVec: prometheus.NewCounterVec(opts, labelNames),
+		name:       prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+		labelNames: labelNames,
+		values:     make(map[string]*state.Sample),
+	}
+	persistentCounters[c.name] = c
+	return c
+}
+
+// Add - add v to the series of labels
+func (c *persistentCounter) Add(labels prometheus.Labels, v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := seriesKey(labels)
+	s, ok := c.values[key]
+	if !ok {
+		s = &state.Sample{Labels: labels}
+		c.values[key] = s
+	}
+	s.Value += v
+	c.With(labels).Add(v)
+}
+
+// DeletePartialMatch - delete the series matching labels, returns how many were deleted
+func (c *persistentCounter) DeletePartialMatch(labels prometheus.Labels) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, s := range c.values {
+		if matchLabels(s.Labels, labels) {
+			delete(c.values, key)
+		}
+	}
+	return c.CounterVec.DeletePartialMatch(labels)
+}
+
+// samples - current values of all series
+func (c *persistentCounter) samples() []state.Sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make([]state.Sample, 0, len(c.values))
+	for _, s := range c.values {
+		result = append(result, *s)
+	}
+	return result
+}
+
+// saveCounters - copy values of all persistent counters into the state
+func saveCounters(s *state.State) {
+	for name, c := range persistentCounters {
+		s.Counters[name] = c.samples()
+	}
+}
+
+// project - the labels of a saved sample reduced to the current label names, false if one of
+// them is missing, e.g. LABEL_ALLOWLIST allows more labels than when the sample was saved
+func (c *persistentCounter) project(labels map[string]string) (prometheus.Labels, bool) {
+	result := make(prometheus.Labels, len(c.labelNames))
+	for _, name := range c.labelNames {
+		value, ok := labels[name]
+		if !ok {
+			return nil, false
+		}
+		result[name] = value
+	}
+	return result, true
+}
+
+// restoreCounters - continue the persistent counters from the state, the samples which
+// collapse into the same series under the current labels are summed up
+func restoreCounters(s *state.State) {
+	for name, samples := range s.Counters {
+		c, ok := persistentCounters[name]
+		if !ok {
+			continue
+		}
+		skipped := 0
+		for _, sample := range samples {
+			labels, ok := c.project(sample.Labels)
+			if !ok {
+				skipped++
+				continue
+			}
+			c.Add(labels, sample.Value)
+		}
+		if skipped > 0 {
+			logrus.Warnf("%d samples of %s skipped, they miss labels of %v", skipped, name, c.labelNames)
+		}
+	}
+}
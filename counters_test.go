@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/state"
+)
+
+func Test_RestoreCounters(t *testing.T) {
+	defer func(l map[string][]string) { LabelAllowlist = l }(LabelAllowlist)
+	saved := []state.Sample{
+		{Labels: map[string]string{"project_id": "1", "item_id": "1", "environment": "production"}, Value: 3},
+		{Labels: map[string]string{"project_id": "1", "item_id": "1", "environment": "staging"}, Value: 2},
+		{Labels: map[string]string{"project_id": "1", "item_id": "2", "environment": "production"}, Value: 1},
+	}
+	for _, c := range []struct {
+		Allowlist map[string][]string
+		Samples   []state.Sample
+		Restored  map[string]float64
+	}{
+		// the same labels as saved
+		{
+			map[string][]string{},
+			saved,
+			map[string]float64{"1/1/production": 3, "1/1/staging": 2, "1/2/production": 1},
+		},
+		// environment is dropped since the samples were saved, the environments of an item are summed up
+		{
+			map[string][]string{"item_occurrences_scraped_total": {}},
+			saved,
+			map[string]float64{"1/1/": 5, "1/2/": 1},
+		},
+		// environment is allowed again, the samples saved without it are skipped
+		{
+			map[string][]string{},
+			[]state.Sample{
+				{Labels: map[string]string{"project_id": "1", "item_id": "1"}, Value: 5},
+				{Labels: map[string]string{"project_id": "1", "item_id": "2", "environment": "production"}, Value: 1},
+			},
+			map[string]float64{"1/2/production": 1},
+		},
+	} {
+		LabelAllowlist = c.Allowlist
+		reset(t)
+		s := state.New()
+		s.Counters[occurrencesScraped.name] = c.Samples
+		restoreCounters(s)
+
+		restored := map[string]float64{}
+		for _, sample := range occurrencesScraped.samples() {
+			restored[sample.Labels["project_id"]+"/"+sample.Labels["item_id"]+"/"+sample.Labels["environment"]] = sample.Value
+		}
+		equals(t, c.Restored, restored)
+	}
+}
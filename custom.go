@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// CustomMetric - a gauge populated by an occurrence metrics query, labeled by
+// project_id and the group_by fields
+type CustomMetric struct {
+	Name       string              `yaml:"name"`
+	Help       string              `yaml:"help"`
+	ProjectIDs []int               `yaml:"project_ids"`
+	Projects   []string            `yaml:"projects"`
+	Window     duration            `yaml:"window"`
+	GroupBy    []rollbar.Field     `yaml:"group_by"`
+	Filters    []rollbar.Filter    `yaml:"filters"`
+	Aggregates []rollbar.Aggregate `yaml:"aggregates"`
+	// Value - the field of the rows exposed as the value, the first aggregate or occurrence_count by default
+	Value rollbar.Field `yaml:"value"`
+}
+
+var filterOperators = map[rollbar.FilterOperator]bool{
+	rollbar.FilterOperatorEq:         true,
+	rollbar.FilterOperatorNe:         true,
+	rollbar.FilterOperatorGt:         true,
+	rollbar.FilterOperatorGte:        true,
+	rollbar.FilterOperatorLt:         true,
+	rollbar.FilterOperatorLte:        true,
+	rollbar.FilterOperatorNotLike:    true,
+	rollbar.FilterOperatorBetween:    true,
+	rollbar.FilterOperatorNotBetween: true,
+}
+
+var aggregateFunctions = map[rollbar.AggregateFunction]bool{
+	rollbar.AggregateFunctionCountAll:      true,
+	rollbar.AggregateFunctionCountDistinct: true,
+	rollbar.AggregateFunctionMax:           true,
+	rollbar.AggregateFunctionMin:           true,
+}
+
+// validateCustomMetrics - check the custom metrics, their labels can't be any of constLabels
+func validateCustomMetrics(cms []CustomMetric, constLabels map[string]string) error {
+	names := make(map[string]bool)
+	for i := range cms {
+		m := &cms[i]
+		if !nameRegex.MatchString(m.Name) {
+			return fmt.Errorf("custom_metrics[%d]: %q is not a valid metric name", i, m.Name)
+		}
+		if names[m.Name] {
+			return fmt.Errorf("custom_metrics[%d]: duplicated name %s", i, m.Name)
+		}
+		if isBuiltinMetric(m.Name) {
+			return fmt.Errorf("custom_metrics[%d]: %s is the name of a built-in metric", i, m.Name)
+		}
+		names[m.Name] = true
+		if m.Help == "" {
+			m.Help = fmt.Sprintf("This is the custom occurrence metric %s", m.Name)
+		}
+		if m.Window != 0 && m.Window < duration(time.Minute) {
+			return fmt.Errorf("custom metric %s: window should be at least 1m", m.Name)
+		}
+		grouped := make(map[rollbar.Field]bool, len(m.GroupBy))
+		for _, f := range m.GroupBy {
+			// the labels of a metric are unique
+			if grouped[f] {
+				return fmt.Errorf("custom metric %s: duplicated group_by field %s", m.Name, f)
+			}
+			grouped[f] = true
+			if f == rollbar.FieldProjectId {
+				return fmt.Errorf("custom metric %s: project_id is always a label, don't group by it", m.Name)
+			}
+			if !f.IsValid() {
+				return fmt.Errorf("custom metric %s: unknown group_by field %q", m.Name, f)
+			}
+			if _, ok := constLabels[string(f)]; ok {
+				return fmt.Errorf("custom metric %s: group_by %s is a const label", m.Name, f)
+			}
+		}
+		for j, f := range m.Filters {
+			if f.Field == "" {
+				return fmt.Errorf("custom metric %s: filters[%d]: field is required", m.Name, j)
+			}
+			if !f.Field.IsValid() {
+				return fmt.Errorf("custom metric %s: filters[%d]: unknown field %q", m.Name, j, f.Field)
+			}
+			if !filterOperators[f.Operator] {
+				return fmt.Errorf("custom metric %s: filters[%d]: unknown operator %q", m.Name, j, f.Operator)
+			}
+		}
+		for j, a := range m.Aggregates {
+			if a.Field == "" {
+				return fmt.Errorf("custom metric %s: aggregates[%d]: field is required", m.Name, j)
+			}
+			if !aggregateFunctions[a.Function] {
+				return fmt.Errorf("custom metric %s: aggregates[%d]: unknown function %q", m.Name, j, a.Function)
+			}
+		}
+		if m.Value == "" {
+			m.Value = rollbar.FieldOccurrenceCount
+			if len(m.Aggregates) > 0 {
+				m.Value = m.Aggregates[0].Field
+				if m.Aggregates[0].Alias != "" {
+					m.Value = rollbar.Field(m.Aggregates[0].Alias)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isBuiltinMetric - whether the name is a built-in metric, or one of the series of a built-in histogram
+func isBuiltinMetric(name string) bool {
+	for _, suffix := range []string{"", "_bucket", "_sum", "_count"} {
+		if strings.HasSuffix(name, suffix) && builtinMetrics[strings.TrimSuffix(name, suffix)] {
+			return true
+		}
+	}
+	return false
+}
+
+// customGauges - metric name to the gauge of the custom metric
+var customGauges = map[string]*prometheus.GaugeVec{}
+
+func registerCustomMetrics() {
+	customGauges = make(map[string]*prometheus.GaugeVec, len(CustomMetrics))
+	for _, m := range CustomMetrics {
+		labels := []string{"project_id"}
+		for _, f := range m.GroupBy {
+			labels = append(labels, string(f))
+		}
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: m.Name,
+			Help: m.Help,
+		}, labels)
+		registry.MustRegister(g)
+		customGauges[m.Name] = g
+	}
+}
+
+// customProjects - the projects of a custom metric, all of ps if none is given
+func customProjects(m CustomMetric, ps []rollbar.Project) []rollbar.Project {
+	if len(m.ProjectIDs) == 0 && len(m.Projects) == 0 {
+		return ps
+	}
+	return sloProjects(SLO{ProjectIDs: m.ProjectIDs, Projects: m.Projects}, ps)
+}
+
+// evaluateCustomMetrics - query and update the custom metrics of the selected projects
+func evaluateCustomMetrics(ps []rollbar.Project, now time.Time) {
+	for _, m := range CustomMetrics {
+		g := customGauges[m.Name]
+		window := time.Duration(m.Window)
+		if window == 0 {
+			window = ScrapeInterval
+		}
+		for _, p := range customProjects(m, ps) {
+			if err := evaluateCustomMetric(m, g, p, now.Add(-window), now); err != nil {
+				logrus.Errorf("%v - custom metric %s, project: [%d]%s", err, m.Name, p.ID, p.Name)
+				countScrapeError(accountOf(p.ID), stageOf(err))
+			}
+		}
+	}
+}
+
+func evaluateCustomMetric(m CustomMetric, g *prometheus.GaugeVec, p rollbar.Project, start, end time.Time) error {
+	token, err := projectToken(p)
+	if err != nil {
+		return &stageError{stageToken, "GetOrCreateProjectReadToken", err}
+	}
+	params := rollbar.NewOccurrencesInputRange(start, end, m.GroupBy...)
+	params.Filters = m.Filters
+	params.Aggregates = m.Aggregates
+	rows, err := accountOf(p.ID).QueryOccurrences(token, params, 0)
+	if err != nil {
+		dropToken(p, token, err)
+		return &stageError{stageOccurrences, "QueryOccurrences", err}
+	}
+
+	pid := fmt.Sprintf("%d", p.ID)
+	g.DeletePartialMatch(prometheus.Labels{"project_id": pid})
+	for _, row := range rows {
+		labels := prometheus.Labels{"project_id": pid}
+		for _, f := range m.GroupBy {
+			labels[string(f)] = row.String(f)
+		}
+		g.With(labels).Add(row.Float(m.Value))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+)
+
+func Test_ValidateCustomMetrics(t *testing.T) {
+	valid := func() CustomMetric {
+		return CustomMetric{
+			Name:    "occurrences_by_browser",
+			Window:  duration(5 * time.Minute),
+			GroupBy: []rollbar.Field{rollbar.FieldEnvironment, rollbar.FieldBrowserFamily},
+			Filters: []rollbar.Filter{{Field: rollbar.FieldItemLevel, Operator: rollbar.FilterOperatorEq}},
+		}
+	}
+	constLabels := map[string]string{"cluster": "prod", "browser_family": "none"}
+	for _, c := range []struct {
+		Name   string
+		Modify func(m *CustomMetric)
+		Error  string
+	}{
+		{"valid", func(m *CustomMetric) { m.GroupBy = m.GroupBy[:1] }, ""},
+		{"invalid name", func(m *CustomMetric) { m.Name = "by-browser" }, "not a valid metric name"},
+		{"built-in name", func(m *CustomMetric) { m.Name = "item_occurrences" }, "built-in metric"},
+		{"built-in histogram series", func(m *CustomMetric) { m.Name = "item_occurrences_bucket" }, "built-in metric"},
+		{"built-in prefix", func(m *CustomMetric) { m.Name, m.GroupBy = "item_occurrences_by_browser", nil }, ""},
+		{"short window", func(m *CustomMetric) { m.Window = duration(time.Second) }, "window should be at least 1m"},
+		{"group by project", func(m *CustomMetric) { m.GroupBy = []rollbar.Field{rollbar.FieldProjectId} }, "project_id is always a label"},
+		{"unknown field", func(m *CustomMetric) { m.GroupBy = []rollbar.Field{"browser"} }, `unknown group_by field "browser"`},
+		{"const label", func(m *CustomMetric) {}, "group_by browser_family is a const label"},
+		{"duplicated group by", func(m *CustomMetric) {
+			m.GroupBy = []rollbar.Field{rollbar.FieldEnvironment, rollbar.FieldEnvironment}
+		}, "duplicated group_by field environment"},
+		{"filter field", func(m *CustomMetric) { m.GroupBy, m.Filters[0].Field = nil, "" }, "filters[0]: field is required"},
+		{"unknown filter field", func(m *CustomMetric) { m.GroupBy, m.Filters[0].Field = nil, "levl" }, `filters[0]: unknown field "levl"`},
+		{"filter operator", func(m *CustomMetric) { m.GroupBy, m.Filters[0].Operator = nil, "like" }, "filters[0]: unknown operator"},
+		{"aggregate function", func(m *CustomMetric) {
+			m.GroupBy = nil
+			m.Aggregates = []rollbar.Aggregate{{Field: rollbar.FieldOccurrenceCount, Function: "sum"}}
+		}, "aggregates[0]: unknown function"},
+	} {
+		m := valid()
+		c.Modify(&m)
+		err := validateCustomMetrics([]CustomMetric{m}, constLabels)
+		if c.Error == "" {
+			assert(t, err == nil, "%s: unexpected error %v", c.Name, err)
+			continue
+		}
+		assert(t, err != nil && strings.Contains(err.Error(), c.Error), "%s: expected %q, got %v", c.Name, c.Error, err)
+	}
+
+	m := valid()
+	assert(t, validateCustomMetrics([]CustomMetric{m, m}, nil) != nil, "duplicated names are rejected")
+
+	cms := []CustomMetric{valid()}
+	ok(t, validateCustomMetrics(cms, nil))
+	equals(t, rollbar.FieldOccurrenceCount, cms[0].Value)
+	assert(t, cms[0].Help != "", "a help is given by default")
+}
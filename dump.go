@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// onlyProjects - IDs or names of the projects to scrape, all the selected ones if empty
+var onlyProjects = map[string]bool{}
+
+// flags of the dump command
+var dumpOpts struct {
+	Projects string
+	Format   string
+}
+
+// dump formats
+const (
+	formatText        = "text"
+	formatOpenMetrics = "openmetrics"
+	formatJSON        = "json"
+)
+
+func dumpFlags(fs *flag.FlagSet) {
+	fs.StringVar(&dumpOpts.Projects, "project", "", "project IDs or names to scrape, comma separated, all the selected ones if empty")
+	fs.StringVar(&dumpOpts.Format, "format", formatText, "text, openmetrics or json")
+}
+
+// dumpCommand - scrape once into a new registry and write its metrics to stdout.
+// The state is neither loaded nor saved and no project token is created, so a running
+// exporter isn't affected. Only the projects of -project are scraped, SLOs included.
+func dumpCommand(args []string) error {
+	switch dumpOpts.Format {
+	case formatText, formatOpenMetrics, formatJSON:
+	default:
+		return fmt.Errorf("unknown format %q", dumpOpts.Format)
+	}
+	if err := configure(); err != nil {
+		return err
+	}
+	// a dump never changes the account
+	createTokens = false
+	for _, p := range strings.Split(dumpOpts.Projects, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			onlyProjects[p] = true
+		}
+	}
+
+	reg := prometheus.NewRegistry()
+	registerMetrics(reg)
+	if err := scrape(); err != nil {
+		return err
+	}
+
+	mfs, err := accountGatherer{reg}.Gather()
+	if err != nil {
+		return err
+	}
+	if ItemMetrics && MinuteResolution {
+		if mf := occurrencesPerMinute.family(); len(mf.Metric) > 0 {
+			mfs = append(mfs, mf)
+		}
+	}
+	return writeFamilies(os.Stdout, mfs, dumpOpts.Format)
+}
+
+// writeFamilies - write the metric families in the format
+func writeFamilies(w io.Writer, mfs []*dto.MetricFamily, format string) error {
+	if format == formatJSON {
+		result := make([]json.RawMessage, 0, len(mfs))
+		for _, mf := range mfs {
+			b, err := protojson.Marshal(protov1.MessageV2(mf))
+			if err != nil {
+				return err
+			}
+			result = append(result, b)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	f := expfmt.FmtText
+	if format == formatOpenMetrics {
+		f = expfmt.FmtOpenMetrics
+	}
+	enc := expfmt.NewEncoder(w, f)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	if c, ok := enc.(expfmt.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
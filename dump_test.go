@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_WriteFamilies(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "project_status", Help: "status"}, []string{"project_id"})
+	reg.MustRegister(g)
+	g.WithLabelValues("1").Set(1)
+	mfs, err := reg.Gather()
+	ok(t, err)
+
+	for _, c := range []struct {
+		Format   string
+		Contains []string
+	}{
+		{formatText, []string{"# TYPE project_status gauge", `project_status{project_id="1"} 1`}},
+		{formatOpenMetrics, []string{"# TYPE project_status gauge", `project_status{project_id="1"} 1`, "# EOF"}},
+		{formatJSON, []string{`"name": "project_status"`}},
+	} {
+		var b bytes.Buffer
+		ok(t, writeFamilies(&b, mfs, c.Format))
+		for _, s := range c.Contains {
+			assert(t, strings.Contains(b.String(), s), "%s: no %q in %s", c.Format, s, b.String())
+		}
+		if c.Format == formatJSON {
+			var families []map[string]any
+			ok(t, json.Unmarshal(b.Bytes(), &families))
+			equals(t, 1, len(families))
+		}
+	}
+}
+
+func Test_DumpProjects(t *testing.T) {
+	reset(t)
+	restoreConfig(t)
+	defer func(slos []SLO) { SLOs = slos; createTokens = true }(SLOs)
+	flagValues = map[string]string{}
+	t.Setenv("ROLLBAR_ACCOUNT_READ_TOKEN", "x")
+	t.Setenv("ROLLBAR_ACCOUNT_WRITE_TOKEN", "y")
+	queried := make(map[string]bool)
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		queried[r.Method+" "+r.URL.Path] = true
+		switch r.URL.Path {
+		case "/projects":
+			fmt.Fprint(w, `{"err":0,"result":[{"id":1,"name":"app","status":"enabled"},{"id":2,"name":"web","status":"enabled"}]}`)
+		case "/project/1/access_tokens", "/project/2/access_tokens":
+			fmt.Fprint(w, `{"err":0,"result":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	SLOs = []SLO{{Name: "all", ProjectIDs: []int{1, 2}, Window: duration(time.Minute), Objective: 1, BurnWindows: defaultBurnWindows}}
+	sloObjective.Reset()
+
+	dumpOpts.Projects, dumpOpts.Format = "app", formatText
+	defer func() { dumpOpts.Projects = "" }()
+	ok(t, dumpCommand(nil))
+	assert(t, queried["GET /project/1/access_tokens"], "the project of -project is scraped")
+	assert(t, !queried["GET /project/2/access_tokens"], "a project out of -project is scraped")
+	assert(t, !queried["POST /project/1/access_tokens"], "dump created a project token")
+	equals(t, 1, testutil.CollectAndCount(sloObjective))
+}
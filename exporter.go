@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Stages of a scrape cycle, as the stage label of scrape errors
+const (
+	stageListProjects = "list_projects"
+	stageToken        = "token"
+	stageOccurrences  = "occurrences"
+	stageItems        = "items"
+)
+
+// stageError - error of a rollbar call within a stage
+type stageError struct {
+	Stage string
+	Call  string
+	Err   error
+}
+
+func (e *stageError) Error() string {
+	return fmt.Sprintf("%s failed - %v", e.Call, e.Err)
+}
+
+func (e *stageError) Unwrap() error {
+	return e.Err
+}
+
+// stageOf - the stage where err happened
+func stageOf(err error) string {
+	var se *stageError
+	if errors.As(err, &se) {
+		return se.Stage
+	}
+	return "unknown"
+}
+
+// metrics of the exporter itself
+var (
+	cycleDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "exporter_scrape_cycle_duration_seconds",
+		Help:    "This is the duration of a scrape cycle over all projects",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	projectScrapeDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "exporter_project_scrape_duration_seconds",
+		Help: "This is the duration of the last scrape of a project",
+	}, []string{
+		"project_id",
+	})
+
+	projectLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "exporter_project_last_success_timestamp_seconds",
+		Help: "This is the unix time of the last successful scrape of a project",
+	}, []string{
+		"project_id",
+	})
+
+	lastCycleSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "exporter_last_cycle_success_timestamp_seconds",
+		Help: "This is the unix time of the last scrape cycle which listed the projects",
+	})
+
+	itemsFetched = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "exporter_items_fetched",
+		Help: "This is the number of items fetched for a project in the last scrape",
+	}, []string{
+		"project_id",
+	})
+
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "exporter_config_reloads_total",
+		Help: "This is the counter of config reloads by result",
+	}, []string{
+		"result",
+	})
+
+	tokenReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "exporter_token_reloads_total",
+		Help: "This is the counter of token reloads from files by token and result",
+	}, []string{
+		"token",
+		"result",
+	})
+)
+
+// per-account metrics of the exporter, created by newExporterMetrics once the accounts are configured
+var (
+	scrapeErrors *prometheus.CounterVec
+	projects     *prometheus.GaugeVec
+)
+
+// accountLabels - the label names, with the account label if the accounts are configured
+func accountLabels(names ...string) []string {
+	if multiAccount() {
+		return append(names, accountLabel)
+	}
+	return names
+}
+
+// accountValues - the label values, with the name of the account if the accounts are configured
+func accountValues(a *account, values ...string) []string {
+	if multiAccount() {
+		return append(values, a.Name)
+	}
+	return values
+}
+
+func newExporterMetrics() {
+	scrapeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "exporter_scrape_errors_total",
+		Help: "This is the counter of scrape errors by stage",
+	}, accountLabels(
+		"stage",
+	))
+
+	projects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "exporter_projects",
+		Help: "This is the number of projects processed or skipped in the last cycle",
+	}, accountLabels(
+		"state",
+	))
+}
+
+// countScrapeError - count an error of the stage in the account
+func countScrapeError(a *account, stage string) {
+	scrapeErrors.WithLabelValues(accountValues(a, stage)...).Inc()
+}
+
+func registerExporterMetrics() {
+	registry.MustRegister(cycleDuration)
+	registry.MustRegister(projectScrapeDuration)
+	registry.MustRegister(projectLastSuccess)
+	registry.MustRegister(lastCycleSuccess)
+	registry.MustRegister(scrapeErrors)
+	registry.MustRegister(projects)
+	registry.MustRegister(itemsFetched)
+	registry.MustRegister(configReloads)
+	registry.MustRegister(tokenReloads)
+
+	// expose the stages before any error happens
+	for _, a := range accounts {
+		for _, stage := range []string{stageListProjects, stageToken, stageOccurrences, stageItems} {
+			scrapeErrors.WithLabelValues(accountValues(a, stage)...)
+		}
+	}
+	configReloads.WithLabelValues("success")
+	configReloads.WithLabelValues("failure")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func Test_ExporterMetrics(t *testing.T) {
+	reset(t)
+	defer func(i bool) { ItemMetrics = i }(ItemMetrics)
+	ItemMetrics = false
+
+	failing := ""
+	api := fakeRollbar(`[[{"field":"environment","value":"production"},{"field":"occurrence_count","value":3}]]`)
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		api(w, r)
+	})
+	cycles := func() uint64 {
+		m := &dto.Metric{}
+		ok(t, cycleDuration.Write(m))
+		return m.GetHistogram().GetSampleCount()
+	}
+	errors := func(stage string) float64 {
+		return testutil.ToFloat64(scrapeErrors.WithLabelValues(stage))
+	}
+	scrapeErrors.Reset()
+	projects.Reset()
+	projectLastSuccess.Reset()
+	projectScrapeDuration.Reset()
+	lastCycleSuccess.Set(0)
+
+	n := cycles()
+	ok(t, scrape())
+	equals(t, n+1, cycles())
+	equals(t, 1, testutil.CollectAndCount(projectScrapeDuration))
+	assert(t, testutil.ToFloat64(projectLastSuccess.WithLabelValues("1")) > 0, "no success of project 1")
+	assert(t, testutil.ToFloat64(lastCycleSuccess) > 0, "no success of the cycle")
+	equals(t, 1.0, testutil.ToFloat64(projects.WithLabelValues("processed")))
+	for _, stage := range []string{stageListProjects, stageToken, stageOccurrences, stageItems} {
+		equals(t, 0.0, errors(stage))
+	}
+
+	// a project failed is counted by its stage, the cycle still succeeds
+	failing = "/metrics/occurrences"
+	projectLastSuccess.Reset()
+	lastCycleSuccess.Set(0)
+	ok(t, scrape())
+	equals(t, n+2, cycles())
+	equals(t, 1.0, errors(stageOccurrences))
+	equals(t, 0, testutil.CollectAndCount(projectLastSuccess))
+	assert(t, testutil.ToFloat64(lastCycleSuccess) > 0, "no success of the cycle")
+
+	// the projects failed to be listed fail the cycle
+	failing = "/projects"
+	lastCycleSuccess.Set(0)
+	assert(t, scrape() != nil, "the cycle succeeded without the projects")
+	equals(t, n+3, cycles())
+	equals(t, 1.0, errors(stageListProjects))
+	equals(t, 1.0, errors(stageOccurrences))
+	equals(t, 0.0, testutil.ToFloat64(lastCycleSuccess))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Labels of the occurrences histogram
+const (
+	histogramPerItem    = "item"
+	histogramPerProject = "project"
+)
+
+// defaultBuckets - occurrence counts of a window, 1 to 32768
+var defaultBuckets = prometheus.ExponentialBuckets(1, 2, 16)
+
+// maxBuckets - the most buckets of a layout, each bucket is a series per item
+const maxBuckets = 100
+
+// parseBuckets - parse a bucket layout, one of
+//
+//	exponential:start,factor,count
+//	linear:start,width,count
+//	explicit upper bounds, e.g. 1,5,10,50
+func parseBuckets(s string) ([]float64, error) {
+	kind, args, ok := strings.Cut(s, ":")
+	if !ok {
+		kind, args = "explicit", s
+	}
+	values := make([]float64, 0)
+	for _, arg := range strings.Split(args, ",") {
+		arg = strings.TrimSpace(arg)
+		if arg == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q - %v", arg, err)
+		}
+		values = append(values, v)
+	}
+
+	switch kind {
+	case "exponential", "linear":
+		if len(values) != 3 {
+			return nil, fmt.Errorf("%s buckets need start,%s,count", kind, map[string]string{"exponential": "factor", "linear": "width"}[kind])
+		}
+		if values[2] != float64(int(values[2])) || values[2] < 1 || values[2] > maxBuckets {
+			return nil, fmt.Errorf("%s buckets need an integer count in [1, %d]", kind, maxBuckets)
+		}
+		if kind == "exponential" {
+			if values[0] <= 0 || values[1] <= 1 {
+				return nil, fmt.Errorf("exponential buckets need start > 0 and factor > 1")
+			}
+			return prometheus.ExponentialBuckets(values[0], values[1], int(values[2])), nil
+		}
+		if values[1] <= 0 {
+			return nil, fmt.Errorf("linear buckets need width > 0")
+		}
+		return prometheus.LinearBuckets(values[0], values[1], int(values[2])), nil
+	case "explicit":
+		if len(values) == 0 {
+			return nil, fmt.Errorf("no bucket is given")
+		}
+		if len(values) > maxBuckets {
+			return nil, fmt.Errorf("more than %d buckets are given", maxBuckets)
+		}
+		for i := 1; i < len(values); i++ {
+			// client_golang panics on duplicated bounds
+			if values[i] <= values[i-1] {
+				return nil, fmt.Errorf("buckets are not strictly increasing")
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unknown bucket layout %q", kind)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_ParseBuckets(t *testing.T) {
+	many := "1"
+	for i := 2; i <= 101; i++ {
+		many += fmt.Sprintf(",%d", i)
+	}
+	for _, c := range []struct {
+		Value   string
+		Buckets []float64
+		Error   bool
+	}{
+		{"1,5,10", []float64{1, 5, 10}, false},
+		{" 1, 5 ,10 ", []float64{1, 5, 10}, false},
+		{"explicit:1,2", []float64{1, 2}, false},
+		{"exponential:1,2,4", []float64{1, 2, 4, 8}, false},
+		{"linear:0,5,3", []float64{0, 5, 10}, false},
+		{"", nil, true},
+		{"1,1,5", nil, true},
+		{"5,1", nil, true},
+		{"1,abc", nil, true},
+		{"exponential:0,2,4", nil, true},
+		{"exponential:1,1,4", nil, true},
+		{"exponential:1,2", nil, true},
+		{"linear:0,0,3", nil, true},
+		{"linear:0,5,0", nil, true},
+		{"linear:0,5,-1", nil, true},
+		{"linear:0,5,2.5", nil, true},
+		{"linear:0,5,101", nil, true},
+		{"exponential:1,2,1e12", nil, true},
+		{"exponential:1,2,1", []float64{1}, false},
+		{"quadratic:1,2,3", nil, true},
+		{many, nil, true},
+	} {
+		buckets, err := parseBuckets(c.Value)
+		equals(t, c.Error, err != nil)
+		equals(t, c.Buckets, buckets)
+	}
+}
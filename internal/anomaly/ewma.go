@@ -0,0 +1,39 @@
+package anomaly
+
+import "math"
+
+// EWMA - exponentially weighted moving average and variance of a series,
+// the baseline an observation is scored against
+type EWMA struct {
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	Count    int     `json:"count"`
+}
+
+// Update - fold x into the baseline, alpha in (0, 1] is the weight of x
+func (e *EWMA) Update(x, alpha float64) {
+	if e.Count == 0 {
+		e.Mean = x
+		e.Variance = 0
+		e.Count = 1
+		return
+	}
+	diff := x - e.Mean
+	incr := alpha * diff
+	e.Mean += incr
+	e.Variance = (1 - alpha) * (e.Variance + diff*incr)
+	e.Count++
+}
+
+// Score - how many standard deviations x is above the baseline. The deviation
+// is at least the square root of the mean (as for a poisson process) and 1,
+// so a quiet series doesn't score a single occurrence as a spike.
+func (e *EWMA) Score(x float64) float64 {
+	if e.Count == 0 {
+		return 0
+	}
+	std := math.Sqrt(e.Variance)
+	std = math.Max(std, math.Sqrt(e.Mean))
+	std = math.Max(std, 1)
+	return (x - e.Mean) / std
+}
@@ -0,0 +1,33 @@
+package anomaly_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/anomaly"
+)
+
+func Test_EWMA(t *testing.T) {
+	var e anomaly.EWMA
+	if s := e.Score(100); s != 0 {
+		t.Fatalf("empty baseline should score 0, got %f", s)
+	}
+
+	for i := 0; i < 50; i++ {
+		e.Update(10, 0.1)
+	}
+	if math.Abs(e.Mean-10) > 1e-9 {
+		t.Fatalf("mean of a constant series should be 10, got %f", e.Mean)
+	}
+	if s := e.Score(11); s > 1 {
+		t.Fatalf("a small change should not spike, got %f", s)
+	}
+	if s := e.Score(100); s < 3 {
+		t.Fatalf("a burst should spike, got %f", s)
+	}
+
+	e.Update(100, 0.1)
+	if e.Mean <= 10 || e.Variance <= 0 {
+		t.Fatalf("the burst should move the baseline, got %+v", e)
+	}
+}
@@ -0,0 +1,141 @@
+package rollbar
+
+import (
+	"sync"
+	"time"
+)
+
+// BaseURL - the API of the accounts without their own
+var BaseURL = "https://api.rollbar.com/api/1"
+
+// Account - a rollbar account, with its API and account access tokens.
+// The tokens may be rotated while requests are in flight.
+type Account struct {
+	// Name - identifies the account, e.g. as a label
+	Name string
+	// BaseURL - the API of the account, the package BaseURL if empty
+	BaseURL string
+
+	mu    sync.RWMutex
+	read  string
+	write string
+}
+
+// NewAccount - an account without tokens, set them by SetReadAccessToken and SetWriteAccessToken
+func NewAccount(name, baseURL string) *Account {
+	return &Account{Name: name, BaseURL: baseURL}
+}
+
+// DefaultAccount - the account of the package level functions
+var DefaultAccount = NewAccount("", "")
+
+func (a *Account) baseURL() string {
+	if a.BaseURL != "" {
+		return a.BaseURL
+	}
+	return BaseURL
+}
+
+// ReadAccessToken - the account token with read scope
+func (a *Account) ReadAccessToken() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.read
+}
+
+// WriteAccessToken - the account token with write scope, empty if not given
+func (a *Account) WriteAccessToken() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.write
+}
+
+// SetReadAccessToken - the requests from now on use the token, the ones in flight are not affected
+func (a *Account) SetReadAccessToken(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.read = token
+}
+
+// SetWriteAccessToken - the requests from now on use the token, the ones in flight are not affected
+func (a *Account) SetWriteAccessToken(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.write = token
+}
+
+// AccountReadAccessToken - the read token of DefaultAccount
+func AccountReadAccessToken() string {
+	return DefaultAccount.ReadAccessToken()
+}
+
+// AccountWriteAccessToken - the write token of DefaultAccount
+func AccountWriteAccessToken() string {
+	return DefaultAccount.WriteAccessToken()
+}
+
+// SetAccountReadAccessToken - set the read token of DefaultAccount
+func SetAccountReadAccessToken(token string) {
+	DefaultAccount.SetReadAccessToken(token)
+}
+
+// SetAccountWriteAccessToken - set the write token of DefaultAccount
+func SetAccountWriteAccessToken(token string) {
+	DefaultAccount.SetWriteAccessToken(token)
+}
+
+func ListProjects() ([]Project, error) {
+	return DefaultAccount.ListProjects()
+}
+
+func ListProjectAccessTokens(projectID int) ([]ProjectAccessToken, error) {
+	return DefaultAccount.ListProjectAccessTokens(projectID)
+}
+
+func CreateProjectAccessToken(projectID int, params CreateProjectAccessTokenParams) (*ProjectAccessToken, error) {
+	return DefaultAccount.CreateProjectAccessToken(projectID, params)
+}
+
+func DeleteProjectAccessToken(projectID int, accessToken string) error {
+	return DefaultAccount.DeleteProjectAccessToken(projectID, accessToken)
+}
+
+func GetProjectReadToken(projectID int) (*ProjectAccessToken, error) {
+	return DefaultAccount.GetProjectReadToken(projectID)
+}
+
+func GetOrCreateProjectReadToken(projectID int) (*ProjectAccessToken, error) {
+	return DefaultAccount.GetOrCreateProjectReadToken(projectID)
+}
+
+func ListEnvrionments(projectToken string) ([]Environment, error) {
+	return DefaultAccount.ListEnvrionments(projectToken)
+}
+
+func GetItemByID(projectToken string, id int) (*Item, error) {
+	return DefaultAccount.GetItemByID(projectToken, id)
+}
+
+func GetOccurrence(projectToken string, id int) (*Occurrence, error) {
+	return DefaultAccount.GetOccurrence(projectToken, id)
+}
+
+func ListItemsWithIDs(projectToken string, ids []int) ([]Item, error) {
+	return DefaultAccount.ListItemsWithIDs(projectToken, ids)
+}
+
+func GetOccurrencesMetrics(projectToken string, params OccurrenceMetricsParams) (*OccurenceMetricsResult, error) {
+	return DefaultAccount.GetOccurrencesMetrics(projectToken, params)
+}
+
+func GetItemOccurrences(projectToken string, ago time.Duration, upTo int) ([]ItemOccurrence, error) {
+	return DefaultAccount.GetItemOccurrences(projectToken, ago, upTo)
+}
+
+func QueryItemOccurrences(projectToken string, params OccurrenceMetricsParams, upTo int) ([]ItemOccurrence, error) {
+	return DefaultAccount.QueryItemOccurrences(projectToken, params, upTo)
+}
+
+func QueryOccurrences(projectToken string, params OccurrenceMetricsParams, upTo int) ([]OccurrenceRow, error) {
+	return DefaultAccount.QueryOccurrences(projectToken, params, upTo)
+}
@@ -3,6 +3,7 @@ package rollbar
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -28,6 +29,21 @@ var (
 	}
 )
 
+// HTTPError - a call answered with a status other than 200
+type HTTPError struct {
+	StatusCode int
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP call failed - %d", e.StatusCode)
+}
+
+// IsUnauthorized - whether err is the token of the call being rejected
+func IsUnauthorized(err error) bool {
+	var e *HTTPError
+	return errors.As(err, &e) && (e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden)
+}
+
 // jcall - helper method for call json and parse object
 func jcall(method, token string, url string, payload []byte, recv any) error {
 	header := http.Header{
@@ -82,7 +98,7 @@ func call(method string, fullURL string, header http.Header, payload []byte) (io
 			}
 			res.Body.Close()
 		}
-		return nil, fmt.Errorf("HTTP call failed - %d", res.StatusCode)
+		return nil, &HTTPError{res.StatusCode}
 	}
 
 	return res.Body, nil
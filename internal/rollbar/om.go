@@ -1,6 +1,12 @@
 package rollbar
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
 
 // Status represents the enabled or disabled status of an entity.
 type Status string
@@ -109,6 +115,59 @@ const (
 	FieldRequestBody        = Field("request_body")
 )
 
+// fields holds every Field above, see IsValid.
+var fields = map[Field]bool{
+	FieldProjectId:          true,
+	FieldItemId:             true,
+	FieldEnvironment:        true,
+	FieldBrowserFamily:      true,
+	FieldBrowserVersion:     true,
+	FieldOsFamily:           true,
+	FieldOsVersion:          true,
+	FieldDeviceBrand:        true,
+	FieldDeviceModel:        true,
+	FieldIpAddress:          true,
+	FieldItemStatus:         true,
+	FieldItemLevel:          true,
+	FieldItemGroupItemId:    true,
+	FieldItemTitle:          true,
+	FieldItemCounter:        true,
+	FieldPersonUsername:     true,
+	FieldPersonEmail:        true,
+	FieldPersonId:           true,
+	FieldCodeVersion:        true,
+	FieldCount:              true,
+	FieldOccurrenceId:       true,
+	FieldUuid:               true,
+	FieldContext:            true,
+	FieldPlatform:           true,
+	FieldFramework:          true,
+	FieldPlatformCanonical:  true,
+	FieldFrameworkCanonical: true,
+	FieldLanguage:           true,
+	FieldLanguageName:       true,
+	FieldNotifierName:       true,
+	FieldNotifierVersion:    true,
+	FieldOccurrenceCount:    true,
+	FieldMessageBody:        true,
+	FieldTimestamp:          true,
+	FieldFingerprint:        true,
+	FieldServerHost:         true,
+	FieldServerRoot:         true,
+	FieldServerPid:          true,
+	FieldServerCpu:          true,
+	FieldScmBranch:          true,
+	FieldRequestUrl:         true,
+	FieldRequestMethod:      true,
+	FieldRequestQueryString: true,
+	FieldRequestBody:        true,
+}
+
+// IsValid reports whether f is one of the fields of the occurrences API.
+func (f Field) IsValid() bool {
+	return fields[f]
+}
+
 // AggregateFunction represents the allowed functions in Aggregate
 type AggregateFunction string
 
@@ -120,7 +179,7 @@ const (
 )
 
 type Aggregate struct {
-	Field    Field             `json:"fileld"`
+	Field    Field             `json:"field"`
 	Function AggregateFunction `json:"function"`
 	Alias    string            `json:"alias"`
 }
@@ -141,7 +200,7 @@ const (
 )
 
 type Filter struct {
-	Field    Field          `json:"fileld"`
+	Field    Field          `json:"field"`
 	Values   []string       `json:"values"`
 	Operator FilterOperator `json:"operator"`
 }
@@ -200,6 +259,63 @@ type OccurenceMetricsResult struct {
 	Timepoints              []TimePoint `json:"timepoints"`
 }
 
+// OccurrenceRow - a row of occurrences metrics, values by the group by fields and aggregates
+type OccurrenceRow struct {
+	Time   time.Time
+	Values map[Field]any
+}
+
+// String - value of the field as string, empty if absent
+func (r OccurrenceRow) String(f Field) string {
+	switch v := r.Values[f].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Int - value of the field as integer, 0 if absent
+func (r OccurrenceRow) Int(f Field) int64 {
+	switch v := r.Values[f].(type) {
+	case nil:
+		return 0
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, err := v.Float64()
+		if err != nil {
+			logrus.Errorf("%v is not int64", v)
+		}
+		return int64(f)
+	default:
+		logrus.Errorf("%v is not int64", v)
+		return 0
+	}
+}
+
+// Float - value of the field as float, 0 if absent
+func (r OccurrenceRow) Float(f Field) float64 {
+	switch v := r.Values[f].(type) {
+	case nil:
+		return 0
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			logrus.Errorf("%v is not float64", v)
+		}
+		return f
+	default:
+		logrus.Errorf("%v is not float64", v)
+		return 0
+	}
+}
+
 type ItemOccurrence struct {
 	Time            time.Time
 	ItemID          int
@@ -225,5 +341,41 @@ type Item struct {
 	FirstOccurrenceTimestamp int    `json:"first_occurrence_timestamp"`
 	LastOccurrenceId         int    `json:"last_occurrence_id"`
 	LastOccurrenceTimestamp  int    `json:"last_occurrence_timestamp"`
+	LastActivatedTimestamp   int    `json:"last_activated_timestamp"`
 	TotalOccurrences         int64  `json:"total_occurrences"`
 }
+
+// Occurrence - a single occurrence of an item, with the payload reported
+type Occurrence struct {
+	ID        int            `json:"id"`
+	ItemID    int            `json:"item_id"`
+	Timestamp int64          `json:"timestamp"`
+	Version   int            `json:"version"`
+	Data      map[string]any `json:"data"`
+}
+
+// CodeVersion - code version of the payload, from data.code_version or the legacy
+// data.client.javascript.code_version, data.server.code_version and data.server.sha
+func (o Occurrence) CodeVersion() string {
+	paths := [][]string{
+		{"code_version"},
+		{"client", "javascript", "code_version"},
+		{"server", "code_version"},
+		{"server", "sha"},
+	}
+	for _, path := range paths {
+		var v any = o.Data
+		for _, key := range path {
+			m, ok := v.(map[string]any)
+			if !ok {
+				v = nil
+				break
+			}
+			v = m[key]
+		}
+		if s, ok := v.(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -4,27 +4,24 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-var BaseURL = "https://api.rollbar.com/api/1"
-
-var AccountReadAccessToken = ""
-var AccountWriteAccessToken = ""
-
 type listProjectsResponse struct {
 	Err    int       `json:"err"`
 	Result []Project `json:"result"`
 }
 
-func ListProjects() ([]Project, error) {
+func (a *Account) ListProjects() ([]Project, error) {
 	var resp listProjectsResponse
 	if err := jcall(
 		"GET",
-		AccountReadAccessToken,
-		fmt.Sprintf("%s/projects", BaseURL),
+		a.ReadAccessToken(),
+		fmt.Sprintf("%s/projects", a.baseURL()),
 		nil,
 		&resp); err != nil {
 		return nil, err
@@ -40,12 +37,12 @@ type listProjectAccessTokensResponse struct {
 	Result []ProjectAccessToken `json:"result"`
 }
 
-func ListProjectAccessTokens(projectID int) ([]ProjectAccessToken, error) {
+func (a *Account) ListProjectAccessTokens(projectID int) ([]ProjectAccessToken, error) {
 	var resp listProjectAccessTokensResponse
 	if err := jcall(
 		"GET",
-		AccountReadAccessToken,
-		fmt.Sprintf("%s/project/%d/access_tokens", BaseURL, projectID),
+		a.ReadAccessToken(),
+		fmt.Sprintf("%s/project/%d/access_tokens", a.baseURL(), projectID),
 		nil,
 		&resp); err != nil {
 		return nil, err
@@ -61,7 +58,7 @@ type createProjectAccessTokenResponse struct {
 	Result ProjectAccessToken `json:"result"`
 }
 
-func CreateProjectAccessToken(projectID int, params CreateProjectAccessTokenParams) (*ProjectAccessToken, error) {
+func (a *Account) CreateProjectAccessToken(projectID int, params CreateProjectAccessTokenParams) (*ProjectAccessToken, error) {
 	var resp createProjectAccessTokenResponse
 	payload, err := json.Marshal(params)
 	if err != nil {
@@ -69,8 +66,8 @@ func CreateProjectAccessToken(projectID int, params CreateProjectAccessTokenPara
 	}
 	if err := jcall(
 		"POST",
-		AccountWriteAccessToken,
-		fmt.Sprintf("%s/project/%d/access_tokens", BaseURL, projectID),
+		a.WriteAccessToken(),
+		fmt.Sprintf("%s/project/%d/access_tokens", a.baseURL(), projectID),
 		payload,
 		&resp); err != nil {
 		return nil, err
@@ -81,12 +78,99 @@ func CreateProjectAccessToken(projectID int, params CreateProjectAccessTokenPara
 	return &resp.Result, nil
 }
 
+type deleteProjectAccessTokenResponse struct {
+	Err int `json:"err"`
+}
+
+// DeleteProjectAccessToken - revoke the access token of the project
+func (a *Account) DeleteProjectAccessToken(projectID int, accessToken string) error {
+	var resp deleteProjectAccessTokenResponse
+	if err := jcall(
+		"DELETE",
+		a.WriteAccessToken(),
+		fmt.Sprintf("%s/project/%d/access_token/%s", a.baseURL(), projectID, accessToken),
+		nil,
+		&resp); err != nil {
+		return err
+	}
+	if resp.Err != 0 {
+		return fmt.Errorf("rollbar returns error code %d", resp.Err)
+	}
+	return nil
+}
+
 var ErrReadTokenNotFound = errors.New("read token is not found")
 
-func GetProjectReadToken(projectID int) (*ProjectAccessToken, error) {
-	tokens, err := ListProjectAccessTokens(projectID)
-	if err != nil {
-		return nil, err
+// TokenOptions - how the project read tokens of the exporter are created, and told from the others
+type TokenOptions struct {
+	// Name - name of the tokens created
+	Name string
+	// Marker - appended to the name of the tokens created, the read tokens with it belong to the exporter
+	Marker               string
+	RateLimitWindowSize  int
+	RateLimitWindowCount int
+	// ReuseOthers - use an enabled read token not belonging to the exporter before creating one
+	ReuseOthers bool
+}
+
+// DefaultTokenMarker - the marker of the tokens created by default
+const DefaultTokenMarker = "(open-metrics-exporter)"
+
+// ExporterToken - the options of the tokens created by GetOrCreateProjectReadToken
+var ExporterToken = TokenOptions{Name: "read", Marker: DefaultTokenMarker}
+
+// TokenName - the name of the tokens created
+func (o TokenOptions) TokenName() string {
+	if o.Marker == "" {
+		return o.Name
+	}
+	return o.Name + " " + o.Marker
+}
+
+// Owns - whether the token belongs to the exporter: a read only token with the marker
+// in its name, or named Name exactly without a marker
+func (o TokenOptions) Owns(t ProjectAccessToken) bool {
+	if !t.readOnly() {
+		return false
+	}
+	if o.Marker != "" {
+		return strings.Contains(t.Name, o.Marker)
+	}
+	return t.Name == o.Name
+}
+
+// Legacy - whether the token is a read only one named Name exactly while there is a marker, as
+// the exporter created them before the marker existed. They are used like the tokens owned,
+// but never revoked, since a token of someone else could have the same name.
+func (o TokenOptions) Legacy(t ProjectAccessToken) bool {
+	return o.Marker != "" && t.readOnly() && t.Name == o.Name
+}
+
+func (t ProjectAccessToken) readOnly() bool {
+	return len(t.Scopes) == 1 && t.Scopes[0] == ScopeRead
+}
+
+// CreatedByExporter - whether the token belongs to the exporter by ExporterToken
+func (t ProjectAccessToken) CreatedByExporter() bool {
+	return ExporterToken.Owns(t)
+}
+
+// SelectReadToken - the token GetProjectReadToken picks among tokens, the first enabled one
+// belonging to the exporter, else the first enabled legacy one, else the first enabled one with
+// read scope if ExporterToken.ReuseOthers
+func SelectReadToken(tokens []ProjectAccessToken) (*ProjectAccessToken, error) {
+	for _, token := range tokens {
+		if token.Status != StatusDisabled && token.CreatedByExporter() {
+			return &token, nil
+		}
+	}
+	for _, token := range tokens {
+		if token.Status != StatusDisabled && ExporterToken.Legacy(token) {
+			return &token, nil
+		}
+	}
+	if !ExporterToken.ReuseOthers {
+		return nil, ErrReadTokenNotFound
 	}
 	for _, token := range tokens {
 		if token.Status == StatusDisabled {
@@ -101,15 +185,25 @@ func GetProjectReadToken(projectID int) (*ProjectAccessToken, error) {
 	return nil, ErrReadTokenNotFound
 }
 
-func GetOrCreateProjectReadToken(projectID int) (*ProjectAccessToken, error) {
-	token, err := GetProjectReadToken(projectID)
+func (a *Account) GetProjectReadToken(projectID int) (*ProjectAccessToken, error) {
+	tokens, err := a.ListProjectAccessTokens(projectID)
+	if err != nil {
+		return nil, err
+	}
+	return SelectReadToken(tokens)
+}
+
+func (a *Account) GetOrCreateProjectReadToken(projectID int) (*ProjectAccessToken, error) {
+	token, err := a.GetProjectReadToken(projectID)
 	if err != nil {
 		if err == ErrReadTokenNotFound {
 			logrus.Debugf("read token of project %d is not found, creating one...", projectID)
-			return CreateProjectAccessToken(projectID, CreateProjectAccessTokenParams{
-				Name:   "read",
-				Scopes: []Scope{ScopeRead},
-				Status: StatusEnabled,
+			return a.CreateProjectAccessToken(projectID, CreateProjectAccessTokenParams{
+				Name:                 ExporterToken.TokenName(),
+				Scopes:               []Scope{ScopeRead},
+				Status:               StatusEnabled,
+				RateLimitWindowSize:  ExporterToken.RateLimitWindowSize,
+				RateLimitWindowCount: ExporterToken.RateLimitWindowCount,
 			})
 		}
 	}
@@ -125,7 +219,7 @@ type listEnvironmentsResult struct {
 	} `json:"result"`
 }
 
-func ListEnvrionments(projectToken string) ([]Environment, error) {
+func (a *Account) ListEnvrionments(projectToken string) ([]Environment, error) {
 	page := 1
 	limit := 5000
 	var result []Environment
@@ -134,7 +228,7 @@ func ListEnvrionments(projectToken string) ([]Environment, error) {
 		if err := jcall(
 			"GET",
 			projectToken,
-			fmt.Sprintf("%s/environments?page=%d&limit=%d", BaseURL, page, limit),
+			fmt.Sprintf("%s/environments?page=%d&limit=%d", a.baseURL(), page, limit),
 			nil,
 			&resp); err != nil {
 			return nil, err
@@ -155,12 +249,12 @@ type getItemByIDResponse struct {
 	Result Item `json:"result"`
 }
 
-func GetItemByID(projectToken string, id int) (*Item, error) {
+func (a *Account) GetItemByID(projectToken string, id int) (*Item, error) {
 	var resp getItemByIDResponse
 	if err := jcall(
 		"GET",
 		projectToken,
-		fmt.Sprintf("%s/item/%d", BaseURL, id),
+		fmt.Sprintf("%s/item/%d", a.baseURL(), id),
 		nil,
 		&resp); err != nil {
 		return nil, err
@@ -171,25 +265,18 @@ func GetItemByID(projectToken string, id int) (*Item, error) {
 	return &resp.Result, nil
 }
 
-type listItemsWithIDsResponse struct {
-	Err    int `json:"err"`
-	Result struct {
-		Items []Item `json:"items"`
-		Page  int    `json:"page"`
-		Limit int    `json:"limit"`
-	} `json:"result"`
+type getOccurrenceResponse struct {
+	Err    int        `json:"err"`
+	Result Occurrence `json:"result"`
 }
 
-func ListItemsWithIDs(projectToken string, ids []int) ([]Item, error) {
-	var resp listItemsWithIDsResponse
-	strIDs := ""
-	for _, id := range ids {
-		strIDs += fmt.Sprint(id) + ","
-	}
+// GetOccurrence - a single occurrence (instance) of an item
+func (a *Account) GetOccurrence(projectToken string, id int) (*Occurrence, error) {
+	var resp getOccurrenceResponse
 	if err := jcall(
 		"GET",
 		projectToken,
-		fmt.Sprintf("%s/items?ids=%s", BaseURL, strIDs),
+		fmt.Sprintf("%s/instance/%d", a.baseURL(), id),
 		nil,
 		&resp); err != nil {
 		return nil, err
@@ -197,7 +284,48 @@ func ListItemsWithIDs(projectToken string, ids []int) ([]Item, error) {
 	if resp.Err != 0 {
 		return nil, fmt.Errorf("rollbar returns error code %d", resp.Err)
 	}
-	return resp.Result.Items, nil
+	return &resp.Result, nil
+}
+
+type listItemsWithIDsResponse struct {
+	Err    int `json:"err"`
+	Result struct {
+		Items []Item `json:"items"`
+		Page  int    `json:"page"`
+		Limit int    `json:"limit"`
+	} `json:"result"`
+}
+
+// itemsPageSize - the items API returns at most one page of 100 items
+const itemsPageSize = 100
+
+func (a *Account) ListItemsWithIDs(projectToken string, ids []int) ([]Item, error) {
+	result := make([]Item, 0, len(ids))
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > itemsPageSize {
+			n = itemsPageSize
+		}
+		var resp listItemsWithIDsResponse
+		strIDs := ""
+		for _, id := range ids[:n] {
+			strIDs += fmt.Sprint(id) + ","
+		}
+		if err := jcall(
+			"GET",
+			projectToken,
+			fmt.Sprintf("%s/items?ids=%s", a.baseURL(), strIDs),
+			nil,
+			&resp); err != nil {
+			return nil, err
+		}
+		if resp.Err != 0 {
+			return nil, fmt.Errorf("rollbar returns error code %d", resp.Err)
+		}
+		result = append(result, resp.Result.Items...)
+		ids = ids[n:]
+	}
+	return result, nil
 }
 
 type getOccurencesMetricsResponse struct {
@@ -205,7 +333,7 @@ type getOccurencesMetricsResponse struct {
 	Result OccurenceMetricsResult `json:"result"`
 }
 
-func GetOccurrencesMetrics(projectToken string, params OccurrenceMetricsParams) (*OccurenceMetricsResult, error) {
+func (a *Account) GetOccurrencesMetrics(projectToken string, params OccurrenceMetricsParams) (*OccurenceMetricsResult, error) {
 	var resp getOccurencesMetricsResponse
 	payload, err := json.Marshal(params)
 	if err != nil {
@@ -214,7 +342,7 @@ func GetOccurrencesMetrics(projectToken string, params OccurrenceMetricsParams)
 	if err := jcall(
 		"POST",
 		projectToken,
-		fmt.Sprintf("%s/metrics/occurrences", BaseURL),
+		fmt.Sprintf("%s/metrics/occurrences", a.baseURL()),
 		payload,
 		&resp); err != nil {
 		return nil, err
@@ -227,35 +355,109 @@ func GetOccurrencesMetrics(projectToken string, params OccurrenceMetricsParams)
 
 func NewItemOccurrencesInput(ago time.Duration, offset, limit int) OccurrenceMetricsParams {
 	end := time.Now()
-	start := end.Add(-ago)
+	return NewItemOccurrencesInputRange(end.Add(-ago), end, offset, limit)
+}
+
+// NewItemOccurrencesInputRange - query occurrences grouped by item and environment between start and end
+func NewItemOccurrencesInputRange(start, end time.Time, offset, limit int) OccurrenceMetricsParams {
 	return OccurrenceMetricsParams{
 		StartTime: start.Unix(),
 		EndTime:   end.Unix(),
 		GroupBy: []Field{
 			FieldItemId,
+			FieldEnvironment,
 		},
 		Offset: offset,
 		Limit:  limit,
 	}
 }
 
-func GetItemOccurrences(projectToken string, ago time.Duration, upTo int) ([]ItemOccurrence, error) {
+// NewOccurrencesInputRange - query occurrences between start and end grouped by the fields
+func NewOccurrencesInputRange(start, end time.Time, groupBy ...Field) OccurrenceMetricsParams {
+	return OccurrenceMetricsParams{
+		StartTime: start.Unix(),
+		EndTime:   end.Unix(),
+		GroupBy:   groupBy,
+	}
+}
 
-	conv := func(v any) int64 {
-		i, err := v.(json.Number).Int64()
-		if err != nil {
-			logrus.Errorf("%v is not int64", v)
+// WithGranularity - returns a copy of the params bucketed by the granularity
+func (p OccurrenceMetricsParams) WithGranularity(g Granularity) OccurrenceMetricsParams {
+	p.Granularity = &g
+	return p
+}
+
+func (a *Account) GetItemOccurrences(projectToken string, ago time.Duration, upTo int) ([]ItemOccurrence, error) {
+	return a.QueryItemOccurrences(projectToken, NewItemOccurrencesInput(ago, 0, 0), upTo)
+}
+
+// QueryItemOccurrences - page through the occurrences metrics of params. If upTo is positive, only the rows
+// of the upTo items with most occurrences are kept, summed over environments and time points.
+func (a *Account) QueryItemOccurrences(projectToken string, params OccurrenceMetricsParams, upTo int) ([]ItemOccurrence, error) {
+	rows, err := a.QueryOccurrences(projectToken, params, 0)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ItemOccurrence, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, ItemOccurrence{
+			Time:            row.Time,
+			ItemID:          int(row.Int(FieldItemId)),
+			Environment:     row.String(FieldEnvironment),
+			ItemTitle:       row.String(FieldItemTitle),
+			ItemStatus:      row.String(FieldItemStatus),
+			ItemLevel:       row.String(FieldItemLevel),
+			OccurrenceCount: row.Int(FieldOccurrenceCount),
+		})
+	}
+	return topItemOccurrences(result, upTo), nil
+}
+
+// topItemOccurrences - the occurrences of the n items with most occurrences, all of them if n is not positive
+func topItemOccurrences(occs []ItemOccurrence, n int) []ItemOccurrence {
+	sums := make(map[int]int64)
+	for _, occ := range occs {
+		sums[occ.ItemID] += occ.OccurrenceCount
+	}
+	if n <= 0 || len(sums) <= n {
+		return occs
+	}
+	ids := make([]int, 0, len(sums))
+	for id := range sums {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if sums[ids[i]] != sums[ids[j]] {
+			return sums[ids[i]] > sums[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	keep := make(map[int]bool, n)
+	for _, id := range ids[:n] {
+		keep[id] = true
+	}
+	logrus.Debugf("keep %d of %d items", n, len(ids))
+	result := make([]ItemOccurrence, 0, len(occs))
+	for _, occ := range occs {
+		if keep[occ.ItemID] {
+			result = append(result, occ)
 		}
-		return i
 	}
+	return result
+}
+
+// QueryOccurrences - page through the occurrences metrics of params as rows, up to upTo rows if positive
+func (a *Account) QueryOccurrences(projectToken string, params OccurrenceMetricsParams, upTo int) ([]OccurrenceRow, error) {
 
 	limit := 50
 
-	result := make([]ItemOccurrence, 0)
+	result := make([]OccurrenceRow, 0)
 
 	for offset := 0; ; offset += limit {
 		logrus.Debugf("query offset:%d, limit:%d", offset, limit)
-		metrics, err := GetOccurrencesMetrics(projectToken, NewItemOccurrencesInput(ago, offset, limit))
+		params.Offset = offset
+		params.Limit = limit
+		metrics, err := a.GetOccurrencesMetrics(projectToken, params)
 		if err != nil {
 			return nil, err
 		}
@@ -263,24 +465,12 @@ func GetItemOccurrences(projectToken string, ago time.Duration, upTo int) ([]Ite
 		for _, tp := range metrics.Timepoints {
 			for _, row := range tp.MetricsRows {
 				logrus.Debugf("%v", row)
-				single := ItemOccurrence{
-					Time: time.Unix(tp.Timestamp, 0),
+				single := OccurrenceRow{
+					Time:   time.Unix(tp.Timestamp, 0),
+					Values: make(map[Field]any, len(row)),
 				}
 				for _, cell := range row {
-					switch cell.Field {
-					case FieldItemId:
-						single.ItemID = int(conv(cell.Value))
-					case FieldOccurrenceCount:
-						single.OccurrenceCount = conv(cell.Value)
-					case FieldEnvironment:
-						single.Environment = cell.Value.(string)
-					case FieldItemTitle:
-						single.ItemTitle = cell.Value.(string)
-					case FieldItemStatus:
-						single.ItemStatus = cell.Value.(string)
-					case FieldItemLevel:
-						single.ItemLevel = cell.Value.(string)
-					}
+					single.Values[cell.Field] = cell.Value
 				}
 				fetched++
 				if upTo > 0 && len(result) >= upTo {
@@ -1,8 +1,11 @@
 package rollbar_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -40,8 +43,8 @@ func equals(tb testing.TB, exp, act any) {
 
 func init() {
 	logrus.SetLevel(logrus.DebugLevel)
-	rollbar.AccountReadAccessToken = os.Getenv("ROLLBAR_ACCOUNT_READ_TOKEN")
-	rollbar.AccountWriteAccessToken = os.Getenv("ROLLBAR_ACCOUNT_WRITE_TOKEN")
+	rollbar.SetAccountReadAccessToken(os.Getenv("ROLLBAR_ACCOUNT_READ_TOKEN"))
+	rollbar.SetAccountWriteAccessToken(os.Getenv("ROLLBAR_ACCOUNT_WRITE_TOKEN"))
 }
 
 func Test_ListProjects(t *testing.T) {
@@ -140,3 +143,241 @@ func Test_ListItemsWithIDs(t *testing.T) {
 		logrus.Printf("%v", item)
 	}
 }
+
+// serve - point rollbar to a fake API for the duration of the test
+func serve(tb testing.TB, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	baseURL := rollbar.BaseURL
+	rollbar.BaseURL = server.URL
+	tb.Cleanup(func() {
+		rollbar.BaseURL = baseURL
+		server.Close()
+	})
+}
+
+func Test_QueryItemOccurrencesWithGranularity(t *testing.T) {
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		var params rollbar.OccurrenceMetricsParams
+		ok(t, json.NewDecoder(r.Body).Decode(&params))
+		assert(t, params.Granularity != nil, "granularity is sent")
+		equals(t, rollbar.GranularityMinute, *params.Granularity)
+		equals(t, []rollbar.Field{rollbar.FieldItemId, rollbar.FieldEnvironment}, params.GroupBy)
+		fmt.Fprint(w, `{"err":0,"result":{"timepoints":[
+			{"timestamp":60,"metrics_rows":[[
+				{"field":"item_id","value":1},
+				{"field":"environment","value":"production"},
+				{"field":"occurrence_count","value":3}]]},
+			{"timestamp":120,"metrics_rows":[[
+				{"field":"item_id","value":1},
+				{"field":"environment","value":"production"},
+				{"field":"occurrence_count","value":5}]]}]}}`)
+	})
+
+	params := rollbar.NewItemOccurrencesInput(time.Hour, 0, 0).WithGranularity(rollbar.GranularityMinute)
+	occs, err := rollbar.QueryItemOccurrences("token", params, 0)
+	ok(t, err)
+	equals(t, 2, len(occs))
+	equals(t, time.Unix(60, 0), occs[0].Time)
+	equals(t, "production", occs[1].Environment)
+	equals(t, int64(5), occs[1].OccurrenceCount)
+}
+
+func Test_QueryItemOccurrencesUpTo(t *testing.T) {
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"err":0,"result":{"timepoints":[
+			{"timestamp":60,"metrics_rows":[
+				[{"field":"item_id","value":1},{"field":"environment","value":"production"},{"field":"occurrence_count","value":3}],
+				[{"field":"item_id","value":1},{"field":"environment","value":"staging"},{"field":"occurrence_count","value":4}],
+				[{"field":"item_id","value":2},{"field":"environment","value":"production"},{"field":"occurrence_count","value":5}],
+				[{"field":"item_id","value":3},{"field":"environment","value":"production"},{"field":"occurrence_count","value":1}]]}]}}`)
+	})
+
+	// items are capped, not rows: item 1 occurred 7 times over its environments
+	occs, err := rollbar.QueryItemOccurrences("token", rollbar.NewItemOccurrencesInput(time.Hour, 0, 0), 2)
+	ok(t, err)
+	ids := make([]int, 0, len(occs))
+	for _, occ := range occs {
+		ids = append(ids, occ.ItemID)
+	}
+	equals(t, []int{1, 1, 2}, ids)
+
+	occs, err = rollbar.QueryItemOccurrences("token", rollbar.NewItemOccurrencesInput(time.Hour, 0, 0), 0)
+	ok(t, err)
+	equals(t, 4, len(occs))
+}
+
+func Test_GetOccurrenceCodeVersion(t *testing.T) {
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		equals(t, "/instance/42", r.URL.Path)
+		fmt.Fprint(w, `{"err":0,"result":{"id":42,"item_id":1,"timestamp":60,
+			"data":{"client":{"javascript":{"code_version":"v1.2.3"}}}}}`)
+	})
+
+	occ, err := rollbar.GetOccurrence("token", 42)
+	ok(t, err)
+	equals(t, 1, occ.ItemID)
+	equals(t, "v1.2.3", occ.CodeVersion())
+}
+
+func Test_SelectReadToken(t *testing.T) {
+	tokens := []rollbar.ProjectAccessToken{
+		{Name: "post_server_item", Scopes: []rollbar.Scope{rollbar.ScopePostServerItem}, Status: rollbar.StatusEnabled},
+		{Name: "read", Scopes: []rollbar.Scope{rollbar.ScopeRead}, Status: rollbar.StatusDisabled},
+		{Name: "read", Scopes: []rollbar.Scope{rollbar.ScopeRead}, Status: rollbar.StatusEnabled, AccessToken: "other"},
+		{Name: "read " + rollbar.DefaultTokenMarker, Scopes: []rollbar.Scope{rollbar.ScopeRead}, Status: rollbar.StatusEnabled, AccessToken: "t"},
+	}
+	token, err := rollbar.SelectReadToken(tokens)
+	ok(t, err)
+	equals(t, "t", token.AccessToken)
+	assert(t, token.CreatedByExporter(), "read token with the marker is created by the exporter")
+	assert(t, !tokens[2].CreatedByExporter(), "read token without the marker is not created by the exporter")
+	assert(t, !tokens[0].CreatedByExporter(), "post_server_item token is not created by the exporter")
+
+	// the read tokens named read exactly were created before the marker, they are used but not owned
+	token, err = rollbar.SelectReadToken(tokens[:3])
+	ok(t, err)
+	equals(t, "other", token.AccessToken)
+	assert(t, rollbar.ExporterToken.Legacy(tokens[2]), "read token named read is legacy")
+	assert(t, !rollbar.ExporterToken.Legacy(tokens[3]), "read token with the marker is not legacy")
+	assert(t, !rollbar.ExporterToken.Legacy(tokens[0]), "post_server_item token is not legacy")
+
+	_, err = rollbar.SelectReadToken(tokens[:2])
+	equals(t, rollbar.ErrReadTokenNotFound, err)
+}
+
+func Test_SelectReadTokenOwnership(t *testing.T) {
+	options := rollbar.ExporterToken
+	t.Cleanup(func() { rollbar.ExporterToken = options })
+
+	tokens := []rollbar.ProjectAccessToken{
+		{Name: "grafana", Scopes: []rollbar.Scope{rollbar.ScopeRead}, Status: rollbar.StatusEnabled, AccessToken: "human"},
+		{Name: "metrics [exporter]", Scopes: []rollbar.Scope{rollbar.ScopeRead}, Status: rollbar.StatusEnabled, AccessToken: "owned"},
+	}
+	rollbar.ExporterToken = rollbar.TokenOptions{Name: "metrics", Marker: "[exporter]"}
+	token, err := rollbar.SelectReadToken(tokens)
+	ok(t, err)
+	equals(t, "owned", token.AccessToken)
+
+	// the read tokens of others aren't reused by default
+	_, err = rollbar.SelectReadToken(tokens[:1])
+	equals(t, rollbar.ErrReadTokenNotFound, err)
+	equals(t, false, options.ReuseOthers)
+
+	rollbar.ExporterToken.ReuseOthers = true
+	token, err = rollbar.SelectReadToken(tokens[:1])
+	ok(t, err)
+	equals(t, "human", token.AccessToken)
+}
+
+func Test_GetOrCreateProjectReadTokenOptions(t *testing.T) {
+	options := rollbar.ExporterToken
+	t.Cleanup(func() { rollbar.ExporterToken = options })
+	rollbar.ExporterToken = rollbar.TokenOptions{
+		Name:                 "metrics",
+		Marker:               "[exporter]",
+		RateLimitWindowSize:  60,
+		RateLimitWindowCount: 100,
+	}
+
+	var created rollbar.CreateProjectAccessTokenParams
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			fmt.Fprint(w, `{"err":0,"result":[{"name":"read","scopes":["read"],"status":"enabled","access_token":"human"}]}`)
+			return
+		}
+		ok(t, json.NewDecoder(r.Body).Decode(&created))
+		fmt.Fprint(w, `{"err":0,"result":{"name":"metrics [exporter]","scopes":["read"],"status":"enabled","access_token":"owned"}}`)
+	})
+
+	token, err := rollbar.GetOrCreateProjectReadToken(1)
+	ok(t, err)
+	equals(t, "owned", token.AccessToken)
+	equals(t, "metrics [exporter]", created.Name)
+	equals(t, 60, created.RateLimitWindowSize)
+	equals(t, 100, created.RateLimitWindowCount)
+}
+
+func Test_DeleteProjectAccessToken(t *testing.T) {
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		equals(t, "DELETE", r.Method)
+		equals(t, "/project/1/access_token/abc", r.URL.Path)
+		fmt.Fprint(w, `{"err":0}`)
+	})
+
+	ok(t, rollbar.DeleteProjectAccessToken(1, "abc"))
+}
+
+func Test_SetAccountReadAccessToken(t *testing.T) {
+	var got []string
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("X-Rollbar-Access-Token"))
+		fmt.Fprint(w, `{"err":0,"result":[]}`)
+	})
+	token := rollbar.AccountReadAccessToken()
+	t.Cleanup(func() { rollbar.SetAccountReadAccessToken(token) })
+
+	rollbar.SetAccountReadAccessToken("old")
+	_, err := rollbar.ListProjects()
+	ok(t, err)
+	rollbar.SetAccountReadAccessToken("new")
+	_, err = rollbar.ListProjects()
+	ok(t, err)
+	equals(t, []string{"old", "new"}, got)
+}
+
+func Test_AccountBaseURL(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Rollbar-Access-Token")
+		fmt.Fprint(w, `{"err":0,"result":[{"id":1,"name":"a"}]}`)
+	}))
+	defer server.Close()
+
+	a := rollbar.NewAccount("other", server.URL)
+	a.SetReadAccessToken("other-read")
+	ps, err := a.ListProjects()
+	ok(t, err)
+	equals(t, "other-read", got)
+	equals(t, 1, len(ps))
+	equals(t, "a", ps[0].Name)
+}
+
+func Test_FilterJSON(t *testing.T) {
+	b, err := json.Marshal(rollbar.OccurrenceMetricsParams{
+		Filters: []rollbar.Filter{{
+			Field:    rollbar.FieldItemLevel,
+			Values:   []string{"error"},
+			Operator: rollbar.FilterOperatorEq,
+		}},
+		Aggregates: []rollbar.Aggregate{{
+			Field:    rollbar.FieldPersonId,
+			Function: rollbar.AggregateFunctionCountDistinct,
+			Alias:    "people",
+		}},
+	})
+	ok(t, err)
+	var m map[string]any
+	ok(t, json.Unmarshal(b, &m))
+	equals(t, "item_level", m["filters"].([]any)[0].(map[string]any)["field"])
+	equals(t, "person_id", m["aggregates"].([]any)[0].(map[string]any)["field"])
+}
+
+func Test_IsUnauthorized(t *testing.T) {
+	for _, c := range []struct {
+		Status       int
+		Unauthorized bool
+	}{
+		{http.StatusUnauthorized, true},
+		{http.StatusForbidden, true},
+		{http.StatusNotFound, false},
+		{http.StatusInternalServerError, false},
+	} {
+		serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.Status)
+		})
+		_, err := rollbar.NewAccount("", "").ListProjects()
+		assert(t, err != nil, "status %d is not an error", c.Status)
+		equals(t, c.Unauthorized, rollbar.IsUnauthorized(err))
+	}
+	assert(t, !rollbar.IsUnauthorized(fmt.Errorf("rollbar returns error code 1")), "an API error is not unauthorized")
+}
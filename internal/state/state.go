@@ -0,0 +1,158 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/anomaly"
+)
+
+// Sample - value of a counter series
+type Sample struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// Item - metadata of an item remembered between scrapes
+type Item struct {
+	ProjectID                int    `json:"project_id"`
+	Environment              string `json:"environment"`
+	Level                    string `json:"level"`
+	Status                   string `json:"status"`
+	FirstOccurrenceTimestamp int64  `json:"first_occurrence_timestamp"`
+	LastOccurrenceTimestamp  int64  `json:"last_occurrence_timestamp"`
+	LastActivatedTimestamp   int64  `json:"last_activated_timestamp"`
+	LastSeen                 int64  `json:"last_seen"`
+	// LastChecked - when the item was last fetched
+	LastChecked int64 `json:"last_checked"`
+}
+
+// State - everything the exporter keeps across restarts
+type State struct {
+	// project ID to project read token
+	Tokens map[int]string `json:"tokens"`
+	// project ID to the unix time which occurrences are scraped up to
+	Watermarks map[int]int64 `json:"watermarks"`
+	// counter name to the values of its series
+	Counters map[string][]Sample `json:"counters"`
+	// item ID to item metadata
+	Items map[int]Item `json:"items"`
+	// series key to the occurrence rate baseline of the item or project
+	Baselines map[string]anomaly.EWMA `json:"baselines"`
+}
+
+// New - an empty state
+func New() *State {
+	return &State{
+		Tokens:     make(map[int]string),
+		Watermarks: make(map[int]int64),
+		Counters:   make(map[string][]Sample),
+		Items:      make(map[int]Item),
+		Baselines:  make(map[string]anomaly.EWMA),
+	}
+}
+
+// fill - make sure none of the maps is nil after decoding
+func (s *State) fill() {
+	if s.Tokens == nil {
+		s.Tokens = make(map[int]string)
+	}
+	if s.Watermarks == nil {
+		s.Watermarks = make(map[int]int64)
+	}
+	if s.Counters == nil {
+		s.Counters = make(map[string][]Sample)
+	}
+	if s.Items == nil {
+		s.Items = make(map[int]Item)
+	}
+	if s.Baselines == nil {
+		s.Baselines = make(map[string]anomaly.EWMA)
+	}
+}
+
+// Store - where the state is persisted
+type Store interface {
+	// Load - returns the last saved state, or an empty state if nothing is saved yet
+	Load() (*State, error)
+	// Save - persist the state
+	Save(*State) error
+}
+
+// ErrCorrupted - the persisted state can't be decoded
+var ErrCorrupted = errors.New("state is corrupted")
+
+// Open - returns the store of path, an in-memory store if path is empty
+func Open(path string) Store {
+	if path == "" {
+		return &memoryStore{}
+	}
+	return &FileStore{Path: path}
+}
+
+// memoryStore - keeps nothing across restarts
+type memoryStore struct {
+	state *State
+}
+
+func (m *memoryStore) Load() (*State, error) {
+	if m.state == nil {
+		return New(), nil
+	}
+	return m.state, nil
+}
+
+func (m *memoryStore) Save(s *State) error {
+	m.state = s
+	return nil
+}
+
+// FileStore - keeps the state as a JSON file
+type FileStore struct {
+	Path string
+}
+
+func (f *FileStore) Load() (*State, error) {
+	b, err := os.ReadFile(f.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return New(), nil
+		}
+		return nil, err
+	}
+	s := New()
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("%w: %s - %v", ErrCorrupted, f.Path, err)
+	}
+	s.fill()
+	return s, nil
+}
+
+// Save - write to a temporary file next to the state then rename it over,
+// so a crash in the middle never leaves a partial state behind.
+func (f *FileStore) Save(s *State) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
@@ -0,0 +1,57 @@
+package state_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/anomaly"
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/state"
+)
+
+func Test_FileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store := state.Open(path)
+
+	s, err := store.Load()
+	if err != nil {
+		t.Fatalf("load missing state - %v", err)
+	}
+	if len(s.Tokens) != 0 {
+		t.Fatalf("expect empty state, got %v", s)
+	}
+
+	s.Tokens[1] = "token"
+	s.Watermarks[1] = 1600000000
+	s.Counters["c"] = []state.Sample{{Labels: map[string]string{"project_id": "1"}, Value: 3}}
+	s.Items[2] = state.Item{ProjectID: 1, Status: "active"}
+	s.Baselines["project:1"] = anomaly.EWMA{Mean: 2.5, Variance: 1, Count: 3}
+	if err := store.Save(s); err != nil {
+		t.Fatalf("save - %v", err)
+	}
+
+	loaded, err := state.Open(path).Load()
+	if err != nil {
+		t.Fatalf("load - %v", err)
+	}
+	if !reflect.DeepEqual(s, loaded) {
+		t.Fatalf("exp: %#v\ngot: %#v", s, loaded)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp"))
+	if len(files) != 0 {
+		t.Fatalf("temporary files are left - %v", files)
+	}
+}
+
+func Test_FileStoreCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"tokens":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.Open(path).Load(); !errors.Is(err, state.ErrCorrupted) {
+		t.Fatalf("expect ErrCorrupted, got %v", err)
+	}
+}
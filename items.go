@@ -0,0 +1,128 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// per-item metrics, created by newItemMetrics once the label allowlists are configured
+var (
+	occurrencesLabels itemLabels
+	occurrences       *prometheus.GaugeVec
+
+	itemStatusLabels itemLabels
+	itemStatus       *prometheus.GaugeVec
+
+	histogramLabels      itemLabels
+	occurenceHistorigram *prometheus.HistogramVec
+
+	lastMinuteLabels      itemLabels
+	occurrencesLastMinute *prometheus.GaugeVec
+
+	scrapedLabels      itemLabels
+	occurrencesScraped *persistentCounter
+
+	perMinuteLabels      itemLabels
+	occurrencesPerMinute *timeSeries
+
+	firstOccurrenceLabels itemLabels
+	itemFirstOccurrence   *prometheus.GaugeVec
+
+	lastOccurrenceLabels itemLabels
+	itemLastOccurrence   *prometheus.GaugeVec
+)
+
+func newItemMetrics() {
+	// the total of an item is over all of its environments, so it has no environment label
+	occurrencesLabels = newItemLabels("item_total_occurrences",
+		"project_id",
+		"item_id",
+	)
+	occurrences = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: occurrencesLabels.name,
+		Help: "This is the counter of total occurrences of an item",
+	}, occurrencesLabels.names)
+
+	itemStatusLabels = newItemLabels("item_status",
+		"item_id",
+		"title",
+		"project_id",
+		"counter_id",
+		"environment",
+		"platform",
+		"framework",
+		"hash",
+		"status",
+		"level",
+	)
+	itemStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: itemStatusLabels.name,
+		Help: "This is the status of item, value is always 1",
+	}, itemStatusLabels.names)
+
+	histogramLabels = newItemLabels("item_occurrences",
+		"project_id",
+		"item_id",
+		"environment",
+	)
+	if HistogramPer == histogramPerProject {
+		// every item of the project is an observation of the same series
+		histogramLabels = newItemLabels("item_occurrences",
+			"project_id",
+			"environment",
+		)
+	}
+	occurenceHistorigram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:                        histogramLabels.name,
+		Help:                        "This is the histogram of item occurences per scrape interval",
+		Buckets:                     HistogramBuckets,
+		NativeHistogramBucketFactor: NativeHistogramFactor,
+	}, histogramLabels.names)
+
+	lastMinuteLabels = newItemLabels("item_occurrences_last_minute",
+		"project_id",
+		"item_id",
+		"environment",
+	)
+	occurrencesLastMinute = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: lastMinuteLabels.name,
+		Help: "This is the occurrences of an item in the last complete minute, only with minute resolution",
+	}, lastMinuteLabels.names)
+
+	scrapedLabels = newItemLabels("item_occurrences_scraped_total",
+		"project_id",
+		"item_id",
+		"environment",
+	)
+	occurrencesScraped = newPersistentCounter(prometheus.CounterOpts{
+		Name: scrapedLabels.name,
+		Help: "This is the counter of occurrences of an item summed over the scraped windows",
+	}, scrapedLabels.names)
+
+	perMinuteLabels = newItemLabels("item_occurrences_per_minute",
+		"project_id",
+		"item_id",
+		"environment",
+	)
+	occurrencesPerMinute = newTimeSeries(
+		perMinuteLabels.name,
+		"This is the occurrences of an item per minute, only with minute resolution",
+	)
+
+	firstOccurrenceLabels = newItemLabels("item_first_occurrence_timestamp_seconds",
+		"project_id",
+		"item_id",
+	)
+	itemFirstOccurrence = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: firstOccurrenceLabels.name,
+		Help: "This is the unix time of the first occurrence of an item",
+	}, firstOccurrenceLabels.names)
+
+	lastOccurrenceLabels = newItemLabels("item_last_occurrence_timestamp_seconds",
+		"project_id",
+		"item_id",
+	)
+	itemLastOccurrence = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: lastOccurrenceLabels.name,
+		Help: "This is the unix time of the last occurrence of an item",
+	}, lastOccurrenceLabels.names)
+}
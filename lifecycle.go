@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/state"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Item statuses in rollbar
+const (
+	itemStatusActive   = "active"
+	itemStatusResolved = "resolved"
+	itemStatusMuted    = "muted"
+)
+
+// Lifecycle transitions of an item, as the transition label
+const (
+	transitionNew         = "new"
+	transitionReactivated = "reactivated"
+	transitionResolved    = "resolved"
+	transitionMuted       = "muted"
+)
+
+var itemTransitions = newPersistentCounter(prometheus.CounterOpts{
+	Name: "item_transitions_total",
+	Help: "This is the counter of item lifecycle transitions - new, reactivated, resolved and muted",
+}, []string{
+	"project_id",
+	"environment",
+	"level",
+	"transition",
+})
+
+// transition - how the item changed since the previous scrape, empty if it didn't.
+// An item never seen before is new only if it first occurred after since. Items forgotten
+// or never tracked, e.g. resolved long ago, are reactivated if the API tells they were
+// activated after since, as are the ones resolved and activated again between two scrapes.
+func transition(prev state.Item, known bool, item rollbar.Item, since int64) string {
+	activated := int64(item.LastActivatedTimestamp)
+	if !known {
+		if int64(item.FirstOccurrenceTimestamp) >= since {
+			return transitionNew
+		}
+		if item.Status == itemStatusActive && activated >= since {
+			return transitionReactivated
+		}
+		return ""
+	}
+	if prev.Status == item.Status {
+		if item.Status == itemStatusActive && prev.LastActivatedTimestamp > 0 && activated > prev.LastActivatedTimestamp {
+			return transitionReactivated
+		}
+		return ""
+	}
+	switch item.Status {
+	case itemStatusActive:
+		if prev.Status == itemStatusResolved || prev.Status == itemStatusMuted {
+			return transitionReactivated
+		}
+	case itemStatusResolved:
+		return transitionResolved
+	case itemStatusMuted:
+		return transitionMuted
+	}
+	return ""
+}
+
+const (
+	// trackedRefreshInterval - how often an item which didn't occur is fetched for its status
+	trackedRefreshInterval = 15 * time.Minute
+	// maxTrackedItems - the most items fetched per project and cycle without occurring
+	maxTrackedItems = 200
+)
+
+// trackedItems - IDs of the items of the project which could change status without occurring,
+// the ones checked longest ago first. Each is refreshed at most every trackedRefreshInterval,
+// and at most maxTrackedItems per cycle, so the items API isn't called for all of them every cycle.
+func trackedItems(projectID int, exclude map[int]bool, now int64) []int {
+	due := now - int64(trackedRefreshInterval.Seconds())
+	ids := make([]int, 0)
+	for id, item := range st.Items {
+		if item.ProjectID != projectID || exclude[id] || item.LastChecked > due {
+			continue
+		}
+		if item.Status == itemStatusActive || item.Status == itemStatusMuted {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := st.Items[ids[i]].LastChecked, st.Items[ids[j]].LastChecked
+		if a != b {
+			return a < b
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > maxTrackedItems {
+		ids = ids[:maxTrackedItems]
+	}
+	return ids
+}
+
+// updateItem - remember the item in the state and count its transition, which is returned.
+// occurred tells whether the item occurred in the window starting at since.
+func updateItem(item rollbar.Item, occurred bool, since int64, now int64) string {
+	prev, known := st.Items[item.ID]
+	t := transition(prev, known, item, since)
+	if t != "" {
+		itemTransitions.Add(prometheus.Labels{
+			"project_id":  fmt.Sprintf("%d", item.ProjectID),
+			"environment": item.Environment,
+			"level":       item.Level,
+			"transition":  t,
+		}, 1)
+	}
+
+	next := state.Item{
+		ProjectID:                item.ProjectID,
+		Environment:              item.Environment,
+		Level:                    item.Level,
+		Status:                   item.Status,
+		FirstOccurrenceTimestamp: int64(item.FirstOccurrenceTimestamp),
+		LastOccurrenceTimestamp:  int64(item.LastOccurrenceTimestamp),
+		LastActivatedTimestamp:   int64(item.LastActivatedTimestamp),
+		LastSeen:                 prev.LastSeen,
+		LastChecked:              now,
+	}
+	if occurred {
+		next.LastSeen = now
+	}
+	st.Items[item.ID] = next
+	return t
+}
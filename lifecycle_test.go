@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/state"
+)
+
+func Test_Transition(t *testing.T) {
+	const since = 1000
+	for _, c := range []struct {
+		Name string
+		Prev *state.Item
+		Item rollbar.Item
+		Exp  string
+	}{
+		{"new", nil, rollbar.Item{Status: "active", FirstOccurrenceTimestamp: 1500}, transitionNew},
+		{"unknown old", nil, rollbar.Item{Status: "active", FirstOccurrenceTimestamp: 500, LastActivatedTimestamp: 500}, ""},
+		{"forgotten reactivated", nil, rollbar.Item{Status: "active", FirstOccurrenceTimestamp: 500, LastActivatedTimestamp: 1200}, transitionReactivated},
+		{"unknown resolved", nil, rollbar.Item{Status: "resolved", FirstOccurrenceTimestamp: 500, LastActivatedTimestamp: 1200}, ""},
+		{"unchanged", &state.Item{Status: "active", LastActivatedTimestamp: 500}, rollbar.Item{Status: "active", LastActivatedTimestamp: 500}, ""},
+		{"resolved", &state.Item{Status: "active"}, rollbar.Item{Status: "resolved"}, transitionResolved},
+		{"muted", &state.Item{Status: "active"}, rollbar.Item{Status: "muted"}, transitionMuted},
+		{"reactivated", &state.Item{Status: "resolved"}, rollbar.Item{Status: "active"}, transitionReactivated},
+		{"unmuted", &state.Item{Status: "muted"}, rollbar.Item{Status: "active"}, transitionReactivated},
+		{"resolved and reactivated between scrapes", &state.Item{Status: "active", LastActivatedTimestamp: 500}, rollbar.Item{Status: "active", LastActivatedTimestamp: 1200}, transitionReactivated},
+		{"activation unknown before", &state.Item{Status: "active"}, rollbar.Item{Status: "active", LastActivatedTimestamp: 1200}, ""},
+	} {
+		prev := state.Item{}
+		if c.Prev != nil {
+			prev = *c.Prev
+		}
+		if got := transition(prev, c.Prev != nil, c.Item, since); got != c.Exp {
+			t.Errorf("%s: exp %q, got %q", c.Name, c.Exp, got)
+		}
+	}
+}
+
+func Test_TrackedItems(t *testing.T) {
+	reset(t)
+	now := int64(100000)
+	recent := now - 60
+	due := now - int64(trackedRefreshInterval.Seconds())
+	st.Items[1] = state.Item{ProjectID: 1, Status: "active", LastChecked: due - 10}
+	st.Items[2] = state.Item{ProjectID: 1, Status: "muted", LastChecked: due - 20}
+	st.Items[3] = state.Item{ProjectID: 1, Status: "resolved", LastChecked: due - 30}
+	st.Items[4] = state.Item{ProjectID: 1, Status: "active", LastChecked: recent}
+	st.Items[5] = state.Item{ProjectID: 2, Status: "active"}
+	st.Items[6] = state.Item{ProjectID: 1, Status: "active"}
+
+	// checked longest ago first, resolved ones only change by occurring
+	equals(t, []int{6, 2, 1}, trackedItems(1, map[int]bool{}, now))
+	equals(t, []int{2, 1}, trackedItems(1, map[int]bool{6: true}, now))
+
+	for id := 10; id < 10+maxTrackedItems; id++ {
+		st.Items[id] = state.Item{ProjectID: 1, Status: "active"}
+	}
+	equals(t, maxTrackedItems, len(trackedItems(1, map[int]bool{}, now)))
+}
@@ -5,73 +5,104 @@ import (
 	"net/http"
 	"os"
 	"regexp"
-	"strconv"
+	"strings"
 	"time"
 
-	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	Port                 = 8080
-	MetricsPath          = "/metrics"
-	HealthPath           = "/healthz"
-	ScrapeInterval       = 5 * time.Minute
-	MaxItemsPerProject   = 0
-	IncludeProjectsRegex = regexp.MustCompile("^.*$")
-	ExcludeProjectsRegex = regexp.MustCompile("^$")
+	Port                     = 8080
+	MetricsPath              = "/metrics"
+	HealthPath               = "/healthz"
+	ScrapeInterval           = 5 * time.Minute
+	MaxItemsPerProject       = 0
+	MinuteResolution         = false
+	ItemMetrics              = true
+	CodeVersionMetrics       = false
+	TopItems                 = 0
+	MaxSeries                = 0
+	TitleMaxLength           = 0
+	TitleNormalize           = false
+	LabelAllowlist           = map[string][]string{}
+	HistogramBuckets         = defaultBuckets
+	HistogramPer             = histogramPerItem
+	NativeHistogramFactor    = 0.0
+	MetricsNamespace         = ""
+	MetricsSubsystem         = ""
+	LegacyMetricNames        = false
+	ConstLabels              = prometheus.Labels{}
+	SLOs                     = []SLO{}
+	CustomMetrics            = []CustomMetric{}
+	ComparisonMetrics        = false
+	ComparisonWindow         = time.Hour
+	AnomalyDetection         = false
+	AnomalyAlpha             = 0.1
+	AnomalyThreshold         = 3.0
+	AnomalyWarmup            = 12
+	TimeSeriesPath           = "/metrics/minutes"
+	StateFile                = ""
+	BackfillPath             = "/backfill"
+	BackfillEndpoint         = false
+	BackfillPeriod           = time.Duration(0)
+	BackfillWindow           = time.Hour
+	BackfillOutput           = ""
+	ProjectOverrides         = []ProjectConfig{}
+	SelectionRules           = []SelectionRule{}
+	Accounts                 = []AccountConfig{}
+	ReadOnly                 = false
+	ProjectTokensFile        = ""
+	IncludeProjectsRegex     = regexp.MustCompile("^.*$")
+	ExcludeProjectsRegex     = regexp.MustCompile("^$")
+	IncludeEnvironmentsRegex = regexp.MustCompile("^.*$")
+	ExcludeEnvironmentsRegex = regexp.MustCompile("^$")
 )
 
 func main() {
+	os.Exit(run(os.Args[1:]))
+}
 
-	if e, ok := os.LookupEnv("LOG_LEVEL"); ok {
-		if l, err := logrus.ParseLevel(e); err == nil {
-			logrus.Infof("Log level from $LOG_LEVEL: %s", l)
-			logrus.SetLevel(l)
+// parseLabelAllowlist - parse metric=label,label;metric=label,...
+func parseLabelAllowlist(s string) (map[string][]string, error) {
+	result := make(map[string][]string)
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
 		}
-	}
-
-	if e, ok := os.LookupEnv("INCLUDE_PROJECTS_REGEX"); ok {
-		if r, err := regexp.Compile(e); err == nil {
-			logrus.Infof("$INCLUDE_PROJECTS_REGEX: %s", e)
-			IncludeProjectsRegex = r
+		name, labels, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q should be metric=label,label", entry)
 		}
-	}
-
-	if e, ok := os.LookupEnv("EXCLUDE_PROJECTS_REGEX"); ok {
-		if r, err := regexp.Compile(e); err == nil {
-			logrus.Infof("$EXCLUDE_PROJECTS_REGEX: %s", e)
-			ExcludeProjectsRegex = r
+		result[name] = make([]string, 0)
+		for _, label := range strings.Split(labels, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				result[name] = append(result[name], label)
+			}
 		}
 	}
+	return result, nil
+}
 
-	if e, ok := os.LookupEnv("PORT"); ok {
-		if p, err := strconv.Atoi(e); err == nil && p > 1024 {
-			Port = p
-			logrus.Infof("Port from $PORT: %d", p)
+// parseConstLabels - parse name=value,name=value
+func parseConstLabels(s string) (prometheus.Labels, error) {
+	result := prometheus.Labels{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
 		}
-	}
-
-	if e, ok := os.LookupEnv("SCRAPE_INTERVAL"); ok {
-		if d, err := time.ParseDuration(e); err == nil && d >= time.Minute {
-			ScrapeInterval = d
-			logrus.Infof("Scrape interval from $SCRAPE_INTERVAL: %s", d)
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q should be name=value", pair)
 		}
+		result[name] = strings.TrimSpace(value)
 	}
-
-	if e, ok := os.LookupEnv("MAX_ITEMS"); ok {
-		if n, err := strconv.Atoi(e); err == nil && n > 0 {
-			MaxItemsPerProject = n
-			logrus.Infof("Max items per project from $MAX_ITEMS: %d", n)
-		}
-	}
-
-	rollbar.AccountReadAccessToken = os.Getenv("ROLLBAR_ACCOUNT_READ_TOKEN")
-	rollbar.AccountWriteAccessToken = os.Getenv("ROLLBAR_ACCOUNT_WRITE_TOKEN")
-
-	startScrape()
-	startHandlers()
+	return result, nil
 }
 
 func startHandlers() error {
@@ -80,7 +111,18 @@ func startHandlers() error {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(accountGatherer{prometheus.DefaultGatherer}, promhttp.HandlerOpts{}),
+	))
+
+	if BackfillEndpoint {
+		http.HandleFunc(BackfillPath, backfillHandler)
+	}
+
+	if ItemMetrics && MinuteResolution {
+		http.Handle(TimeSeriesPath, timeSeriesHandler(occurrencesPerMinute))
+	}
 
 	strPort := fmt.Sprintf(":%d", Port)
 	logrus.Infof("Start listening on %s...", strPort)
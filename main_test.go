@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/state"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func assert(tb testing.TB, condition bool, msg string, v ...any) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		log.Printf("%s:%d: "+msg+"\n\n", append([]any{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		log.Printf("%s:%d: unexpected error: %s\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+func equals(tb testing.TB, exp, act any) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		log.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+// reset - a fresh state and registry, returns the registry the metrics are registered in
+func reset(tb testing.TB) *prometheus.Registry {
+	st = state.New()
+	onlyProjects = map[string]bool{}
+	overrides = map[int]ProjectConfig{}
+	projectAccounts = map[int]*account{}
+	limiter = newSeriesLimiter()
+	reg := prometheus.NewRegistry()
+	registerMetrics(reg)
+	return reg
+}
+
+// serve - point the default account to a fake API for the duration of the test
+func serve(tb testing.TB, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	baseURL := rollbar.BaseURL
+	rollbar.BaseURL = server.URL
+	tb.Cleanup(func() {
+		rollbar.BaseURL = baseURL
+		server.Close()
+	})
+}
+
+// fakeRollbar - an API of project 1 named "app" with its read token, answering every
+// occurrences query with rows, a JSON array of metrics rows
+func fakeRollbar(rows string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/projects":
+			fmt.Fprint(w, `{"err":0,"result":[{"id":1,"name":"app","status":"enabled"}]}`)
+		case "/project/1/access_tokens":
+			fmt.Fprint(w, `{"err":0,"result":[{"name":"read","scopes":["read"],"status":"enabled","access_token":"t"}]}`)
+		case "/metrics/occurrences":
+			fmt.Fprintf(w, `{"err":0,"result":{"timepoints":[{"timestamp":60,"metrics_rows":%s}]}}`, rows)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
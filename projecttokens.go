@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// provisioned - project reference to its read token, from the project tokens file,
+// and project ID to the provisioned token rejected by rollbar
+var provisioned = struct {
+	sync.RWMutex
+	tokens   map[string]string
+	rejected map[int]string
+}{tokens: map[string]string{}, rejected: map[int]string{}}
+
+// projectTokensSetter - Set of the project tokens file, a YAML map of project reference to read token.
+// A reference is a project ID or name, prefixed by account/ for a project of the accounts.
+func projectTokensSetter(accounts []AccountConfig) func(string) error {
+	names := make(map[string]bool, len(accounts))
+	for _, a := range accounts {
+		names[a.Name] = true
+	}
+	return func(content string) error {
+		tokens := make(map[string]string)
+		if err := yaml.Unmarshal([]byte(content), &tokens); err != nil {
+			return err
+		}
+		for ref, token := range tokens {
+			if token == "" {
+				return fmt.Errorf("the token of project %s is empty", ref)
+			}
+			if name, _, ok := strings.Cut(ref, "/"); ok && !names[name] {
+				return fmt.Errorf("the account of project %s is unknown", ref)
+			}
+		}
+		provisioned.Lock()
+		defer provisioned.Unlock()
+		provisioned.tokens = tokens
+		return nil
+	}
+}
+
+// provisionedToken - the token of the project in the project tokens file, by account/ID, account/name,
+// ID then name. A token rejected by rollbar is skipped until the file changes it.
+func provisionedToken(p rollbar.Project) (string, bool) {
+	refs := []string{strconv.Itoa(p.ID), p.Name}
+	if a := accountOf(p.ID); a.Name != "" {
+		refs = append([]string{a.Name + "/" + refs[0], a.Name + "/" + refs[1]}, refs...)
+	}
+	provisioned.RLock()
+	defer provisioned.RUnlock()
+	for _, ref := range refs {
+		if token, ok := provisioned.tokens[ref]; ok {
+			if token == provisioned.rejected[p.ID] {
+				return "", false
+			}
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// dropToken - forget the cached token of the project after a failed call, a token rejected
+// by rollbar is reported missing, and skipped if it is the provisioned one
+func dropToken(p rollbar.Project, token string, err error) {
+	delete(st.Tokens, p.ID)
+	if !rollbar.IsUnauthorized(err) {
+		return
+	}
+	logrus.Warnf("the read token of project [%d]%s is rejected - %v", p.ID, p.Name, err)
+	provisioned.Lock()
+	provisioned.rejected[p.ID] = token
+	provisioned.Unlock()
+	observeTokenMissing(p, true)
+}
+
+var projectTokenMissing = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "exporter_project_token_missing",
+	Help: "This is 1 if a selected project has no read token the exporter could use, 0 otherwise",
+}, []string{
+	"project_id",
+})
+
+// missing - project ID to whether its token was missing last time, to log only the changes
+var (
+	missingMu sync.Mutex
+	missing   = map[int]bool{}
+)
+
+// observeTokenMissing - update the missing token gauge of the project, logs once it changes
+func observeTokenMissing(p rollbar.Project, isMissing bool) {
+	v := 0.0
+	if isMissing {
+		v = 1
+	}
+	projectTokenMissing.WithLabelValues(fmt.Sprintf("%d", p.ID)).Set(v)
+
+	missingMu.Lock()
+	defer missingMu.Unlock()
+	if missing[p.ID] == isMissing {
+		return
+	}
+	missing[p.ID] = isMissing
+	if !isMissing {
+		logrus.Infof("project [%d]%s has a read token now", p.ID, p.Name)
+	} else if ReadOnly {
+		logrus.Warnf("project [%d]%s has no read token of the exporter, add one to the project tokens file or allow token_reuse_others, the exporter won't create it in read only mode", p.ID, p.Name)
+	} else {
+		logrus.Warnf("project [%d]%s has no read token, add one to the project tokens file or give a write token to create it", p.ID, p.Name)
+	}
+}
+
+// createTokens - whether projectToken may create the missing read tokens, the debug commands only look them up
+var createTokens = true
+
+// projectToken - read token of the project: the one in the project tokens file, else the one cached
+// in the state, else an existing read token of the project, which is only created with a write
+// token, out of read only mode and if createTokens
+func projectToken(p rollbar.Project) (string, error) {
+	if token, ok := provisionedToken(p); ok {
+		observeTokenMissing(p, false)
+		return token, nil
+	}
+	if token, ok := st.Tokens[p.ID]; ok {
+		observeTokenMissing(p, false)
+		return token, nil
+	}
+	a := accountOf(p.ID)
+	var t *rollbar.ProjectAccessToken
+	var err error
+	if ReadOnly || !createTokens || a.WriteAccessToken() == "" {
+		t, err = a.GetProjectReadToken(p.ID)
+	} else {
+		t, err = a.GetOrCreateProjectReadToken(p.ID)
+	}
+	if errors.Is(err, rollbar.ErrReadTokenNotFound) {
+		observeTokenMissing(p, true)
+	}
+	if err != nil {
+		return "", err
+	}
+	observeTokenMissing(p, false)
+	st.Tokens[p.ID] = t.AccessToken
+	return t.AccessToken, nil
+}
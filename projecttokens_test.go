@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_ProjectTokensSetter(t *testing.T) {
+	set := projectTokensSetter([]AccountConfig{{Name: "acme"}})
+	for _, c := range []struct {
+		Content string
+		Error   string
+	}{
+		{"checkout: a\n\"12345\": b\n", ""},
+		{"acme/checkout: a\nacme/12345: b\n", ""},
+		{"checkout: \"\"\n", "the token of project checkout is empty"},
+		{"other/checkout: a\n", "the account of project other/checkout is unknown"},
+		{"[checkout]\n", "cannot unmarshal"},
+	} {
+		err := set(c.Content)
+		if c.Error == "" {
+			ok(t, err)
+			continue
+		}
+		assert(t, err != nil && strings.Contains(err.Error(), c.Error), "%q: expected %q, got %v", c.Content, c.Error, err)
+	}
+}
+
+func Test_ProvisionedToken(t *testing.T) {
+	reset(t)
+	defer func() { ok(t, projectTokensSetter(nil)("")) }()
+	acme := &account{Account: rollbar.NewAccount("acme", "")}
+	other := &account{Account: rollbar.NewAccount("other", "")}
+	projectAccounts = map[int]*account{1: acme, 2: other}
+	ok(t, projectTokensSetter([]AccountConfig{{Name: "acme"}, {Name: "other"}})(
+		"app: by-name\n\"1\": by-id\nacme/app: by-account-name\nacme/1: by-account-id\nother/web: web\n"))
+
+	for _, c := range []struct {
+		Project rollbar.Project
+		Token   string
+	}{
+		{rollbar.Project{ID: 1, Name: "app"}, "by-account-id"},
+		{rollbar.Project{ID: 2, Name: "app"}, "by-name"},
+		{rollbar.Project{ID: 2, Name: "web"}, "web"},
+		{rollbar.Project{ID: 3, Name: "web"}, ""},
+	} {
+		token, _ := provisionedToken(c.Project)
+		equals(t, c.Token, token)
+	}
+}
+
+func Test_DropToken(t *testing.T) {
+	reset(t)
+	projectTokenMissing.Reset()
+	defer func() {
+		ok(t, projectTokensSetter(nil)(""))
+		provisioned.rejected = map[int]string{}
+	}()
+	p := rollbar.Project{ID: 1, Name: "app"}
+	set := projectTokensSetter(nil)
+	ok(t, set("app: a\n"))
+
+	// any failure drops the cached token
+	st.Tokens[1] = "cached"
+	dropToken(p, "cached", &rollbar.HTTPError{StatusCode: 500})
+	_, cached := st.Tokens[1]
+	assert(t, !cached, "the cached token is kept")
+	token, _ := provisionedToken(p)
+	equals(t, "a", token)
+
+	// a rejected token is reported missing and the provisioned one isn't used any more
+	dropToken(p, "a", &rollbar.HTTPError{StatusCode: 401})
+	equals(t, 1.0, testutil.ToFloat64(projectTokenMissing.WithLabelValues("1")))
+	_, found := provisionedToken(p)
+	assert(t, !found, "the rejected token is used")
+
+	// until the file changes it
+	ok(t, set("app: b\n"))
+	token, _ = provisionedToken(p)
+	equals(t, "b", token)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var projectRecency = newRecencyCollector()
+
+// recencyCollector - ages of the projects, computed when collected so they
+// keep growing between scrapes
+type recencyCollector struct {
+	sinceLastOccurrence *prometheus.Desc
+	newestItemAge       *prometheus.Desc
+
+	mu     sync.Mutex
+	last   map[int]int64
+	newest map[int]int64
+}
+
+func newRecencyCollector() *recencyCollector {
+	return &recencyCollector{
+		sinceLastOccurrence: prometheus.NewDesc(
+			"project_seconds_since_last_occurrence",
+			"This is the seconds since the last occurrence of any item in a project",
+			[]string{"project_id"}, nil,
+		),
+		newestItemAge: prometheus.NewDesc(
+			"project_newest_item_age_seconds",
+			"This is the seconds since the first occurrence of the newest item in a project",
+			[]string{"project_id"}, nil,
+		),
+		last:   make(map[int]int64),
+		newest: make(map[int]int64),
+	}
+}
+
+func (c *recencyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.sinceLastOccurrence
+	ch <- c.newestItemAge
+}
+
+func (c *recencyCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for id, ts := range c.last {
+		ch <- prometheus.MustNewConstMetric(c.sinceLastOccurrence, prometheus.GaugeValue,
+			now.Sub(time.Unix(ts, 0)).Seconds(), fmt.Sprintf("%d", id))
+	}
+	for id, ts := range c.newest {
+		ch <- prometheus.MustNewConstMetric(c.newestItemAge, prometheus.GaugeValue,
+			now.Sub(time.Unix(ts, 0)).Seconds(), fmt.Sprintf("%d", id))
+	}
+}
+
+// update - recompute the recency of the project from the items in the state, the series of
+// the project are dropped without any item
+func (c *recencyCollector) update(projectID int) {
+	var last, newest int64
+	for _, item := range st.Items {
+		if item.ProjectID != projectID {
+			continue
+		}
+		if item.LastOccurrenceTimestamp > last {
+			last = item.LastOccurrenceTimestamp
+		}
+		if item.FirstOccurrenceTimestamp > newest {
+			newest = item.FirstOccurrenceTimestamp
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the items of the project are all forgotten, see itemRetention
+	if last > 0 {
+		c.last[projectID] = last
+	} else {
+		delete(c.last, projectID)
+	}
+	if newest > 0 {
+		c.newest[projectID] = newest
+	} else {
+		delete(c.newest, projectID)
+	}
+}
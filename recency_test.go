@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_Recency(t *testing.T) {
+	reset(t)
+	now := time.Now().Unix()
+	api := fakeRollbar(`[[{"field":"item_id","value":1},{"field":"environment","value":"production"},{"field":"occurrence_count","value":3}]]`)
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/items" {
+			fmt.Fprintf(w, `{"err":0,"result":{"items":[{"id":1,"project_id":1,"environment":"production","status":"active",`+
+				`"level":"error","first_occurrence_timestamp":%d,"last_occurrence_timestamp":%d}]}}`, now-3600, now-60)
+			return
+		}
+		api(w, r)
+	})
+	ok(t, scrape())
+
+	item := prometheus.Labels{"project_id": "1", "item_id": "1"}
+	equals(t, float64(now-3600), testutil.ToFloat64(itemFirstOccurrence.With(item)))
+	equals(t, float64(now-60), testutil.ToFloat64(itemLastOccurrence.With(item)))
+
+	// the ages keep growing from the timestamps of the items
+	ages := func() map[string]float64 {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(projectRecency)
+		mfs, err := reg.Gather()
+		ok(t, err)
+		result := map[string]float64{}
+		for _, mf := range mfs {
+			for _, m := range mf.GetMetric() {
+				if m.GetLabel()[0].GetValue() == "1" {
+					result[mf.GetName()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+		return result
+	}
+	for name, age := range map[string]float64{
+		"project_seconds_since_last_occurrence": 60,
+		"project_newest_item_age_seconds":       3600,
+	} {
+		v := ages()[name]
+		assert(t, v >= age && v < age+5, "%s is %v, expected %v", name, v, age)
+	}
+
+	// the item series not written for a while are dropped
+	expireItemSeries(time.Now().Add(staleSeriesAfter + time.Minute))
+	equals(t, 0, testutil.CollectAndCount(itemFirstOccurrence))
+	equals(t, 0, testutil.CollectAndCount(itemLastOccurrence))
+
+	// the project ages are dropped with the last item forgotten
+	delete(st.Items, 1)
+	projectRecency.update(1)
+	equals(t, map[string]float64{}, ages())
+}
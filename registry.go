@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// registry - where the exporter registers its metrics, wrapped with the
+// metric prefix and the constant labels by setupRegistry
+var registry prometheus.Registerer = prometheus.DefaultRegisterer
+
+// setupRegistry - register into base, applying MetricsNamespace, MetricsSubsystem
+// and ConstLabels, unless LegacyMetricNames keeps the names unprefixed
+func setupRegistry(base prometheus.Registerer) {
+	registry = base
+	if len(ConstLabels) > 0 {
+		registry = prometheus.WrapRegistererWith(ConstLabels, registry)
+	}
+	if prefix := metricPrefix(); prefix != "" {
+		registry = prometheus.WrapRegistererWithPrefix(prefix, registry)
+	}
+}
+
+// builtinMetrics - the names of the built-in metrics without prefix, a custom metric can't be one of them
+var builtinMetrics = map[string]bool{
+	"exporter_config_reloads_total":                   true,
+	"exporter_item_series":                            true,
+	"exporter_items_fetched":                          true,
+	"exporter_last_cycle_success_timestamp_seconds":   true,
+	"exporter_project_last_success_timestamp_seconds": true,
+	"exporter_project_scrape_duration_seconds":        true,
+	"exporter_project_selected":                       true,
+	"exporter_project_token_missing":                  true,
+	"exporter_projects":                               true,
+	"exporter_scrape_cycle_duration_seconds":          true,
+	"exporter_scrape_errors_total":                    true,
+	"exporter_series_dropped_total":                   true,
+	"exporter_token_reloads_total":                    true,
+	"item_anomaly_score":                              true,
+	"item_first_occurrence_timestamp_seconds":         true,
+	"item_last_occurrence_timestamp_seconds":          true,
+	"item_occurrences":                                true,
+	"item_occurrences_last_minute":                    true,
+	"item_occurrences_per_minute":                     true,
+	"item_occurrences_scraped_total":                  true,
+	"item_spiking":                                    true,
+	"item_status":                                     true,
+	"item_total_occurrences":                          true,
+	"item_transitions_total":                          true,
+	"project_anomaly_score":                           true,
+	"project_newest_item_age_seconds":                 true,
+	"project_occurrences":                             true,
+	"project_occurrences_ratio":                       true,
+	"project_occurrences_total":                       true,
+	"project_occurrences_window":                      true,
+	"project_seconds_since_last_occurrence":           true,
+	"project_spiking":                                 true,
+	"project_status":                                  true,
+	"release_new_items_total":                         true,
+	"release_occurrences":                             true,
+	"release_occurrences_total":                       true,
+	"slo_burn_rate":                                   true,
+	"slo_error_budget_remaining":                      true,
+	"slo_objective":                                   true,
+	"slo_occurrences":                                 true,
+}
+
+// builtinLabels - the labels of the built-in metrics, a constant label can't be one of them
+var builtinLabels = map[string]bool{
+	"account_id":   true,
+	"code_version": true,
+	"counter_id":   true,
+	"environment":  true,
+	"framework":    true,
+	"hash":         true,
+	"item_id":      true,
+	"level":        true,
+	"metric":       true,
+	"name":         true,
+	"offset":       true,
+	"platform":     true,
+	"project_id":   true,
+	"reason":       true,
+	"result":       true,
+	"slo":          true,
+	"stage":        true,
+	"state":        true,
+	"status":       true,
+	"title":        true,
+	"token":        true,
+	"transition":   true,
+	"window":       true,
+}
+
+// metricPrefix - prefix of all metric names, e.g. rollbar_
+func metricPrefix() string {
+	if LegacyMetricNames {
+		return ""
+	}
+	parts := make([]string, 0, 2)
+	for _, part := range []string{MetricsNamespace, MetricsSubsystem} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, "_") + "_"
+}
+
+// metricName - the exposed name of a metric, for the metrics not registered in the registry
+func metricName(name string) string {
+	return metricPrefix() + name
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// describer - a Registerer keeping the descriptions of the collectors registered
+type describer struct {
+	descs []*prometheus.Desc
+}
+
+func (d *describer) Register(c prometheus.Collector) error {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	for desc := range ch {
+		d.descs = append(d.descs, desc)
+	}
+	return nil
+}
+
+func (d *describer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		_ = d.Register(c)
+	}
+}
+
+func (d *describer) Unregister(prometheus.Collector) bool {
+	return true
+}
+
+func Test_BuiltinLabels(t *testing.T) {
+	reset(t)
+	defer func(c, a, v, i, m bool) {
+		ComparisonMetrics, AnomalyDetection, CodeVersionMetrics, ItemMetrics, MinuteResolution = c, a, v, i, m
+	}(ComparisonMetrics, AnomalyDetection, CodeVersionMetrics, ItemMetrics, MinuteResolution)
+	ComparisonMetrics, AnomalyDetection, CodeVersionMetrics, ItemMetrics, MinuteResolution = true, true, true, true, true
+
+	d := &describer{}
+	registerMetrics(d)
+	assert(t, len(d.descs) > 0, "no metric is registered")
+	variableLabels := regexp.MustCompile(`variableLabels: \[([^\]]*)\]`)
+	for _, desc := range d.descs {
+		m := variableLabels.FindStringSubmatch(desc.String())
+		assert(t, m != nil, "no labels in %s", desc)
+		for _, label := range strings.Fields(m[1]) {
+			assert(t, builtinLabels[label], "%s of %s is not in builtinLabels", label, desc)
+		}
+	}
+}
+
+func Test_BuiltinMetrics(t *testing.T) {
+	reset(t)
+	defer func(c, a, v, i, m bool) {
+		ComparisonMetrics, AnomalyDetection, CodeVersionMetrics, ItemMetrics, MinuteResolution = c, a, v, i, m
+	}(ComparisonMetrics, AnomalyDetection, CodeVersionMetrics, ItemMetrics, MinuteResolution)
+	ComparisonMetrics, AnomalyDetection, CodeVersionMetrics, ItemMetrics, MinuteResolution = true, true, true, true, true
+
+	d := &describer{}
+	registerMetrics(d)
+	fqName := regexp.MustCompile(`fqName: "([^"]*)"`)
+	for _, desc := range d.descs {
+		m := fqName.FindStringSubmatch(desc.String())
+		assert(t, m != nil, "no name in %s", desc)
+		name := strings.TrimPrefix(m[1], metricPrefix())
+		assert(t, builtinMetrics[name], "%s is not in builtinMetrics", name)
+	}
+	assert(t, builtinMetrics[perMinuteLabels.name], "%s is not in builtinMetrics", perMinuteLabels.name)
+}
+
+func Test_MetricPrefix(t *testing.T) {
+	defer func(n, s string, l bool) {
+		MetricsNamespace, MetricsSubsystem, LegacyMetricNames = n, s, l
+	}(MetricsNamespace, MetricsSubsystem, LegacyMetricNames)
+
+	for _, c := range []struct {
+		Namespace string
+		Subsystem string
+		Legacy    bool
+		Name      string
+	}{
+		{"", "", false, "item_occurrences"},
+		{"rollbar", "", false, "rollbar_item_occurrences"},
+		{"rollbar", "prod", false, "rollbar_prod_item_occurrences"},
+		{"", "prod", false, "prod_item_occurrences"},
+		{"rollbar", "prod", true, "item_occurrences"},
+	} {
+		MetricsNamespace, MetricsSubsystem, LegacyMetricNames = c.Namespace, c.Subsystem, c.Legacy
+		equals(t, c.Name, metricName("item_occurrences"))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// unknownVersion - code_version of occurrences reported without one
+const unknownVersion = "unknown"
+
+var (
+	codeVersionOccurrences = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "release_occurrences",
+		Help: "This is the occurrences of a project per scrape interval in the last scraped window by environment and code version",
+	}, []string{
+		"project_id",
+		"environment",
+		"code_version",
+	})
+
+	codeVersionOccurrencesTotal = newPersistentCounter(prometheus.CounterOpts{
+		Name: "release_occurrences_total",
+		Help: "This is the counter of occurrences of a project by environment and code version",
+	}, []string{
+		"project_id",
+		"environment",
+		"code_version",
+	})
+
+	codeVersionNewItems = newPersistentCounter(prometheus.CounterOpts{
+		Name: "release_new_items_total",
+		Help: "This is the counter of new items by the code version of their first occurrence",
+	}, []string{
+		"project_id",
+		"environment",
+		"code_version",
+	})
+)
+
+// queryCodeVersions - occurrences of the project grouped by environment and code version
+func queryCodeVersions(projectID int, token string, params rollbar.OccurrenceMetricsParams) ([]rollbar.OccurrenceRow, error) {
+	params.GroupBy = []rollbar.Field{
+		rollbar.FieldEnvironment,
+		rollbar.FieldCodeVersion,
+	}
+	params.Granularity = nil
+	return accountOf(projectID).QueryOccurrences(token, params, 0)
+}
+
+// observeCodeVersions - update the occurrence metrics by code version of the project, the gauge with the counts scaled by scale
+func observeCodeVersions(projectID int, rows []rollbar.OccurrenceRow, scale float64) {
+	pid := fmt.Sprintf("%d", projectID)
+	codeVersionOccurrences.DeletePartialMatch(prometheus.Labels{"project_id": pid})
+	for _, row := range rows {
+		env := row.String(rollbar.FieldEnvironment)
+		if !matchEnvironment(projectID, env) {
+			continue
+		}
+		version := row.String(rollbar.FieldCodeVersion)
+		if version == "" {
+			version = unknownVersion
+		}
+		labels := prometheus.Labels{
+			"project_id":   pid,
+			"environment":  env,
+			"code_version": version,
+		}
+		count := float64(row.Int(rollbar.FieldOccurrenceCount))
+		codeVersionOccurrences.With(labels).Add(count * scale)
+		codeVersionOccurrencesTotal.Add(labels, count)
+	}
+}
+
+// observeNewItem - count the new item by the code version of its first occurrence
+func observeNewItem(projectID int, token string, item rollbar.Item) {
+	version := unknownVersion
+	occ, err := accountOf(projectID).GetOccurrence(token, item.FirstOccurrenceId)
+	if err != nil {
+		logrus.Errorf("GetOccurrence failed - item %d, occurrence %d, %v", item.ID, item.FirstOccurrenceId, err)
+	} else if v := occ.CodeVersion(); v != "" {
+		version = v
+	}
+	codeVersionNewItems.Add(prometheus.Labels{
+		"project_id":   fmt.Sprintf("%d", item.ProjectID),
+		"environment":  item.Environment,
+		"code_version": version,
+	}, 1)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_ObserveCodeVersions(t *testing.T) {
+	reset(t)
+	defer func(exclude *regexp.Regexp) { ExcludeEnvironmentsRegex = exclude }(ExcludeEnvironmentsRegex)
+	ExcludeEnvironmentsRegex = regexp.MustCompile("^staging$")
+	codeVersionOccurrencesTotal.DeletePartialMatch(prometheus.Labels{"project_id": "1"})
+
+	row := func(env, version string, count int) rollbar.OccurrenceRow {
+		return rollbar.OccurrenceRow{Values: map[rollbar.Field]any{
+			rollbar.FieldEnvironment:     env,
+			rollbar.FieldCodeVersion:     version,
+			rollbar.FieldOccurrenceCount: json.Number(fmt.Sprintf("%d", count)),
+		}}
+	}
+	totals := func() map[string]float64 {
+		result := map[string]float64{}
+		for _, s := range codeVersionOccurrencesTotal.samples() {
+			result[s.Labels["environment"]+"/"+s.Labels["code_version"]] = s.Value
+		}
+		return result
+	}
+
+	observeCodeVersions(1, []rollbar.OccurrenceRow{
+		row("production", "v1", 3),
+		row("production", "", 2),
+		row("staging", "v1", 7),
+	}, 2)
+	equals(t, 6.0, testutil.ToFloat64(codeVersionOccurrences.WithLabelValues("1", "production", "v1")))
+	equals(t, 4.0, testutil.ToFloat64(codeVersionOccurrences.WithLabelValues("1", "production", unknownVersion)))
+	equals(t, 2, testutil.CollectAndCount(codeVersionOccurrences))
+	equals(t, map[string]float64{"production/v1": 3, "production/unknown": 2}, totals())
+
+	// the versions gone are removed from the gauge, the counters keep counting
+	observeCodeVersions(1, []rollbar.OccurrenceRow{row("production", "v2", 1)}, 1)
+	equals(t, 1.0, testutil.ToFloat64(codeVersionOccurrences.WithLabelValues("1", "production", "v2")))
+	equals(t, 1, testutil.CollectAndCount(codeVersionOccurrences))
+	equals(t, map[string]float64{"production/v1": 3, "production/unknown": 2, "production/v2": 1}, totals())
+}
+
+func Test_ObserveNewItem(t *testing.T) {
+	defer func(v bool) { CodeVersionMetrics = v }(CodeVersionMetrics)
+	CodeVersionMetrics = true
+	reset(t)
+	codeVersionNewItems.DeletePartialMatch(prometheus.Labels{"project_id": "1"})
+
+	now := time.Now().Unix()
+	api := fakeRollbar(`[` +
+		`[{"field":"item_id","value":1},{"field":"environment","value":"production"},{"field":"occurrence_count","value":1}],` +
+		`[{"field":"item_id","value":2},{"field":"environment","value":"production"},{"field":"occurrence_count","value":1}],` +
+		`[{"field":"item_id","value":3},{"field":"environment","value":"production"},{"field":"occurrence_count","value":1}]]`)
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/items":
+			// items 1 and 2 first occurred in the window, item 3 a day ago
+			fmt.Fprintf(w, `{"err":0,"result":{"items":[`+
+				`{"id":1,"project_id":1,"environment":"production","status":"active","first_occurrence_id":10,"first_occurrence_timestamp":%d},`+
+				`{"id":2,"project_id":1,"environment":"production","status":"active","first_occurrence_id":20,"first_occurrence_timestamp":%d},`+
+				`{"id":3,"project_id":1,"environment":"production","status":"active","first_occurrence_id":30,"first_occurrence_timestamp":%d}]}}`,
+				now, now, now-86400)
+		case "/instance/10":
+			fmt.Fprint(w, `{"err":0,"result":{"id":10,"item_id":1,"data":{"code_version":"v2"}}}`)
+		case "/instance/20":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case "/instance/30":
+			t.Error("the occurrence of an old item is fetched")
+		default:
+			api(w, r)
+		}
+	})
+	ok(t, scrape())
+
+	// the new item without its first occurrence is counted as unknown
+	newItems := map[string]float64{}
+	for _, s := range codeVersionNewItems.samples() {
+		newItems[s.Labels["environment"]+"/"+s.Labels["code_version"]] = s.Value
+	}
+	equals(t, map[string]float64{"production/v2": 1, "production/unknown": 1}, newItems)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	projectOccurrences = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "project_occurrences",
+		Help: "This is the occurrences of a project per scrape interval in the last scraped window by environment and level",
+	}, []string{
+		"project_id",
+		"environment",
+		"level",
+	})
+
+	projectOccurrencesTotal = newPersistentCounter(prometheus.CounterOpts{
+		Name: "project_occurrences_total",
+		Help: "This is the counter of occurrences of a project by environment and level",
+	}, []string{
+		"project_id",
+		"environment",
+		"level",
+	})
+)
+
+// queryRollups - occurrences of the project between start and end grouped by environment and level
+func queryRollups(projectID int, token string, params rollbar.OccurrenceMetricsParams) ([]rollbar.OccurrenceRow, error) {
+	params.GroupBy = []rollbar.Field{
+		rollbar.FieldEnvironment,
+		rollbar.FieldItemLevel,
+	}
+	params.Granularity = nil
+	return accountOf(projectID).QueryOccurrences(token, params, 0)
+}
+
+// observeRollups - update the project level occurrence metrics, the gauge with the counts scaled by scale
+func observeRollups(projectID int, rows []rollbar.OccurrenceRow, scale float64) {
+	pid := fmt.Sprintf("%d", projectID)
+	projectOccurrences.DeletePartialMatch(prometheus.Labels{"project_id": pid})
+	for _, row := range rows {
+		env := row.String(rollbar.FieldEnvironment)
+		if !matchEnvironment(projectID, env) {
+			continue
+		}
+		labels := prometheus.Labels{
+			"project_id":  pid,
+			"environment": env,
+			"level":       row.String(rollbar.FieldItemLevel),
+		}
+		count := float64(row.Int(rollbar.FieldOccurrenceCount))
+		projectOccurrences.With(labels).Add(count * scale)
+		projectOccurrencesTotal.Add(labels, count)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_Rollups(t *testing.T) {
+	reset(t)
+	defer func(top int) { TopItems = top }(TopItems)
+	TopItems = 1
+
+	// the same occurrences grouped by environment and level, and by item and environment
+	rollups := fakeRollbar(`[` +
+		`[{"field":"environment","value":"production"},{"field":"item_level","value":"error"},{"field":"occurrence_count","value":5}],` +
+		`[{"field":"environment","value":"production"},{"field":"item_level","value":"warning"},{"field":"occurrence_count","value":2}],` +
+		`[{"field":"environment","value":"staging"},{"field":"item_level","value":"error"},{"field":"occurrence_count","value":1}]]`)
+	items := fakeRollbar(`[` +
+		`[{"field":"item_id","value":1},{"field":"environment","value":"production"},{"field":"occurrence_count","value":4}],` +
+		`[{"field":"item_id","value":2},{"field":"environment","value":"production"},{"field":"occurrence_count","value":2}],` +
+		`[{"field":"item_id","value":3},{"field":"environment","value":"production"},{"field":"occurrence_count","value":1}],` +
+		`[{"field":"item_id","value":4},{"field":"environment","value":"staging"},{"field":"occurrence_count","value":1}]]`)
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics/occurrences":
+			var params rollbar.OccurrenceMetricsParams
+			ok(t, json.NewDecoder(r.Body).Decode(&params))
+			for _, f := range params.GroupBy {
+				if f == rollbar.FieldItemLevel {
+					rollups(w, r)
+					return
+				}
+			}
+			items(w, r)
+		case "/items":
+			fmt.Fprint(w, `{"err":0,"result":{"items":[]}}`)
+		default:
+			rollups(w, r)
+		}
+	})
+	projectOccurrencesTotal.DeletePartialMatch(prometheus.Labels{"project_id": "1"})
+	ok(t, scrape())
+
+	byLevel := map[string]float64{}
+	for _, s := range projectOccurrencesTotal.samples() {
+		equals(t, "1", s.Labels["project_id"])
+		byLevel[s.Labels["environment"]+"/"+s.Labels["level"]] += s.Value
+	}
+	equals(t, map[string]float64{"production/error": 5, "production/warning": 2, "staging/error": 1}, byLevel)
+
+	// the items out of the top are folded into other, the sums by environment still match
+	byItem := map[string]float64{}
+	byEnv := map[string]float64{}
+	for _, s := range occurrencesScraped.samples() {
+		byItem[s.Labels["item_id"]+"/"+s.Labels["environment"]] += s.Value
+		byEnv[s.Labels["environment"]] += s.Value
+	}
+	equals(t, map[string]float64{"1/production": 4, "other/production": 3, "other/staging": 1}, byItem)
+	equals(t, 2, len(byEnv))
+	for env, v := range byEnv {
+		equals(t, byLevel[env+"/error"]+byLevel[env+"/warning"], v)
+	}
+
+	// the gauges of the first window, which is a whole scrape interval
+	equals(t, 5.0, testutil.ToFloat64(projectOccurrences.WithLabelValues("1", "production", "error")))
+	equals(t, 3, testutil.CollectAndCount(projectOccurrences))
+}
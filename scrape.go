@@ -2,40 +2,17 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/state"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	occurrences = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "item_total_occurrences",
-		Help: "This is the counter of total occurrences of an item",
-	},
-		[]string{
-			"project_id",
-			"item_id",
-		},
-	)
-
-	itemStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "item_status",
-		Help: "This is the status of item, value is always 1",
-	}, []string{
-		"item_id",
-		"title",
-		"project_id",
-		"counter_id",
-		"environment",
-		"platform",
-		"framework",
-		"hash",
-		"status",
-		"level",
-	})
-
 	projectStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "project_status",
 		Help: "This is the status of project, value is always 1",
@@ -45,22 +22,58 @@ var (
 		"account_id",
 		"status",
 	})
-
-	occurenceHistorigram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name: "item_occurrences",
-		Help: "This is the histogram of item occurences",
-	}, []string{
-		"project_id",
-		"item_id",
-	})
 )
 
+// registerMetrics - create the metrics and register the enabled ones into base
+func registerMetrics(base prometheus.Registerer) {
+	setupRegistry(base)
+	newItemMetrics()
+	newAnomalyMetrics()
+	newExporterMetrics()
+
+	registry.MustRegister(projectStatus)
+	registry.MustRegister(projectOccurrences)
+	registry.MustRegister(projectOccurrencesTotal)
+	registerExporterMetrics()
+	// SLOs could be added by a config reload
+	registerSLOMetrics()
+	registerCustomMetrics()
+	registry.MustRegister(projectSelection)
+	registry.MustRegister(projectTokenMissing)
+	if ComparisonMetrics {
+		registerComparisonMetrics()
+	}
+	if AnomalyDetection {
+		registerAnomalyMetrics()
+	}
+	if CodeVersionMetrics {
+		registry.MustRegister(codeVersionOccurrences)
+		registry.MustRegister(codeVersionOccurrencesTotal)
+		if ItemMetrics {
+			registry.MustRegister(codeVersionNewItems)
+		}
+	}
+	if ItemMetrics {
+		registry.MustRegister(seriesDropped)
+		registry.MustRegister(itemSeries)
+		registry.MustRegister(occurrences)
+		registry.MustRegister(itemStatus)
+		registry.MustRegister(occurenceHistorigram)
+		registry.MustRegister(occurrencesScraped)
+		registry.MustRegister(itemTransitions)
+		registry.MustRegister(itemFirstOccurrence)
+		registry.MustRegister(itemLastOccurrence)
+		registry.MustRegister(projectRecency)
+		if MinuteResolution {
+			registry.MustRegister(occurrencesLastMinute)
+		}
+	}
+}
+
 func startScrape() {
 
-	prometheus.MustRegister(occurrences)
-	prometheus.MustRegister(itemStatus)
-	prometheus.MustRegister(projectStatus)
-	prometheus.MustRegister(occurenceHistorigram)
+	registerMetrics(prometheus.DefaultRegisterer)
+	loadState()
 
 	logrus.Infof("Start scraping with interval %s...", ScrapeInterval)
 
@@ -69,101 +82,448 @@ func startScrape() {
 		if err := scrape(); err != nil {
 			logrus.Errorf("scrape failed - %v", err)
 		}
+		saveState()
 		logrus.Infof("scraping done (%s).", time.Since(t))
 	}
 
 	go func() {
+		if BackfillPeriod > 0 {
+			startupBackfill()
+		}
 		s(time.Now())
-		for now := range time.Tick(ScrapeInterval) {
-			s(now)
+		timer := time.NewTimer(ScrapeInterval)
+		for {
+			select {
+			case now := <-timer.C:
+				s(now)
+				timer.Reset(ScrapeInterval)
+			case c := <-reloads:
+				interval := ScrapeInterval
+				applyReload(c)
+				if ScrapeInterval != interval {
+					if !timer.Stop() {
+						<-timer.C
+					}
+					timer.Reset(ScrapeInterval)
+				}
+			}
 		}
 	}()
 }
 
-var tokens = make(map[int]string)
+const (
+	// maxCatchUp - how far back a watermark is followed, older ones fall back to ScrapeInterval
+	maxCatchUp = 24 * time.Hour
+	// itemRetention - items not seen for this long are forgotten, a forgotten item reactivated
+	// later is still counted by its last activation
+	itemRetention = 7 * 24 * time.Hour
+)
+
+var (
+	store = state.Open("")
+	st    = state.New()
+)
+
+// loadState - restore the state saved by the previous run
+func loadState() {
+	store = state.Open(StateFile)
+	s, err := store.Load()
+	if err != nil {
+		logrus.Errorf("load state failed, start from scratch - %v", err)
+		return
+	}
+	st = s
+	restoreCounters(st)
+	logrus.Infof("state loaded - %d tokens, %d watermarks, %d items", len(st.Tokens), len(st.Watermarks), len(st.Items))
+}
+
+// saveState - persist the state after a cycle
+func saveState() {
+	cycle.Lock()
+	defer cycle.Unlock()
+
+	expired := time.Now().Add(-itemRetention).Unix()
+	for id, item := range st.Items {
+		if item.LastSeen < expired {
+			delete(st.Items, id)
+		}
+	}
+	saveCounters(st)
+	if err := store.Save(st); err != nil {
+		logrus.Errorf("save state failed - %v", err)
+	}
+}
+
+// window - the time range to query for the project, continues from its watermark if recent enough.
+// With MinuteResolution the window ends at the start of the minute in progress, so the minutes are
+// never split between two windows.
+func window(projectID int, now time.Time) (time.Time, time.Time) {
+	end := now
+	if MinuteResolution {
+		end = now.Truncate(time.Minute)
+	}
+	start := end.Add(-ScrapeInterval)
+	if wm, ok := st.Watermarks[projectID]; ok {
+		t := time.Unix(wm, 0)
+		if !t.After(end) && end.Sub(t) <= maxCatchUp {
+			start = t
+		}
+	}
+	return start, end
+}
+
+// intervalScale - scales the occurrences of the window to one ScrapeInterval. The gauges and the histogram
+// observe the scaled counts, so a window caught up from a watermark is comparable with the regular ones,
+// while the counters take the counts of the whole window.
+func intervalScale(start, end time.Time) float64 {
+	w := end.Sub(start)
+	if w < time.Minute {
+		w = time.Minute
+	}
+	return ScrapeInterval.Seconds() / w.Seconds()
+}
+
+// cycle - serializes the scrape cycles and backfills, which share the state
+var cycle sync.Mutex
 
 func scrape() error {
-	ps, err := rollbar.ListProjects()
+	cycle.Lock()
+	defer cycle.Unlock()
+
+	started := time.Now()
+	defer func() {
+		cycleDuration.Observe(time.Since(started).Seconds())
+	}()
+
+	ps, err := listProjects()
 	if err != nil {
-		logrus.Errorf("ListProjects failed - %v", err)
 		return err
 	}
 
+	resolveOverrides(ps)
+	// projects could be deleted or renamed since the last cycle
+	projectSelection.Reset()
+	projectTokenMissing.Reset()
+
+	processed, skipped := make(map[*account]int), make(map[*account]int)
+	selected := make([]rollbar.Project, 0, len(ps))
 	for _, p := range ps {
-		if !IncludeProjectsRegex.MatchString(p.Name) || ExcludeProjectsRegex.MatchString(p.Name) {
-			logrus.Infof("skip project [%d]%s", p.ID, p.Name)
+		a := accountOf(p.ID)
+		if !selectProject(p) {
+			skipped[a]++
 			continue
 		}
-		if p.Status == rollbar.StatusDisabled {
-			logrus.Infof("skip disabled project [%d]%s", p.ID, p.Name)
+		processed[a]++
+		selected = append(selected, p)
+
+		logrus.Infof("process project [%d]%s", p.ID, p.Name)
+
+		t := time.Now()
+		err := scrapeProject(p)
+		pid := fmt.Sprintf("%d", p.ID)
+		projectScrapeDuration.WithLabelValues(pid).Set(time.Since(t).Seconds())
+		if err != nil {
+			logrus.Errorf("%v - project: [%d]%s", err, p.ID, p.Name)
+			countScrapeError(a, stageOf(err))
 			continue
 		}
+		projectLastSuccess.WithLabelValues(pid).SetToCurrentTime()
+	}
+	if ItemMetrics {
+		expireItemSeries(time.Now())
+	}
+	if len(SLOs) > 0 {
+		evaluateSLOs(selected, time.Now())
+	}
+	if len(CustomMetrics) > 0 {
+		evaluateCustomMetrics(selected, time.Now())
+	}
 
-		logrus.Infof("process project [%d]%s", p.ID, p.Name)
+	// the accounts failed to list their projects have none
+	for _, a := range accounts {
+		projects.WithLabelValues(accountValues(a, "processed")...).Set(float64(processed[a]))
+		projects.WithLabelValues(accountValues(a, "skipped")...).Set(float64(skipped[a]))
+	}
+	lastCycleSuccess.SetToCurrentTime()
 
-		// set project_status
-		projectStatus.WithLabelValues(
-			fmt.Sprintf("%d", p.ID),        /* project_id */
-			p.Name,                         /* name */
-			fmt.Sprintf("%d", p.AccountID), /* account_id */
-			string(p.Status),               /* status */
-		).Set(1)
-
-		token, ok := tokens[p.ID]
-		if !ok {
-			t, err := rollbar.GetOrCreateProjectReadToken(p.ID)
-			if err != nil {
-				logrus.Errorf("GetOrCreateProjectReadToken failed - project: [%d]%s, %v", p.ID, p.Name, err)
-				continue
-			}
-			token = t.AccessToken
-			tokens[p.ID] = token
+	return nil
+}
+
+// scrapeProject - update the metrics of a single project
+func scrapeProject(p rollbar.Project) error {
+	// set project_status
+	projectStatus.WithLabelValues(
+		fmt.Sprintf("%d", p.ID),        /* project_id */
+		p.Name,                         /* name */
+		fmt.Sprintf("%d", p.AccountID), /* account_id */
+		string(p.Status),               /* status */
+	).Set(1)
+
+	token, err := projectToken(p)
+	if err != nil {
+		return &stageError{stageToken, "GetOrCreateProjectReadToken", err}
+	}
+
+	start, end := window(p.ID, time.Now())
+	if !start.Before(end) {
+		// the minute after the last window is still in progress
+		return nil
+	}
+	params := rollbar.NewItemOccurrencesInputRange(start, end, 0, 0)
+	rollups, err := queryRollups(p.ID, token, params)
+	if err != nil {
+		dropToken(p, token, err)
+		return &stageError{stageOccurrences, "QueryOccurrences", err}
+	}
+
+	var versions []rollbar.OccurrenceRow
+	if CodeVersionMetrics {
+		versions, err = queryCodeVersions(p.ID, token, params)
+		if err != nil {
+			dropToken(p, token, err)
+			return &stageError{stageOccurrences, "QueryOccurrences", err}
 		}
+	}
 
-		occs, err := rollbar.GetItemOccurrences(token, ScrapeInterval, MaxItemsPerProject)
+	if ComparisonMetrics {
+		comparisons, err := queryComparisons(p.ID, token, end)
 		if err != nil {
-			logrus.Errorf("GetItemOccurrences failed - project: [%d]%s, %v", p.ID, p.Name, err)
-			delete(tokens, p.ID)
-			continue
+			dropToken(p, token, err)
+			return &stageError{stageOccurrences, "QueryOccurrences", err}
 		}
+		observeComparisons(p.ID, comparisons)
+	}
 
-		ids := make([]int, 0)
-		for _, occ := range occs {
-			ids = append(ids, occ.ItemID)
-			occurenceHistorigram.WithLabelValues(
-				fmt.Sprintf("%d", p.ID),       /* project_id */
-				fmt.Sprintf("%d", occ.ItemID), /* item_id */
-			).Observe(float64(occ.OccurrenceCount))
+	if !ItemMetrics {
+		observeRollups(p.ID, rollups, intervalScale(start, end))
+		if AnomalyDetection {
+			observeProjectAnomaly(p.ID, rollups, end.Sub(start))
 		}
+		if CodeVersionMetrics {
+			observeCodeVersions(p.ID, versions, intervalScale(start, end))
+		}
+		st.Watermarks[p.ID] = end.Unix()
+		return nil
+	}
 
-		items, err := rollbar.ListItemsWithIDs(token, ids)
-		if err != nil {
-			logrus.Errorf("ListItemsWithIDs failed - project: [%d]%s, %v", p.ID, p.Name, err)
-			delete(tokens, p.ID)
+	if MinuteResolution {
+		params = params.WithGranularity(rollbar.GranularityMinute)
+	}
+	// the whole window is fetched, MAX_ITEMS is applied to the items by observeOccurrences
+	occs, err := accountOf(p.ID).QueryItemOccurrences(token, params, 0)
+	if err != nil {
+		dropToken(p, token, err)
+		return &stageError{stageOccurrences, "QueryItemOccurrences", err}
+	}
+
+	// apply all queries of the window together, so the counters never count a window twice
+	observeRollups(p.ID, rollups, intervalScale(start, end))
+	if AnomalyDetection {
+		observeProjectAnomaly(p.ID, rollups, end.Sub(start))
+	}
+	if CodeVersionMetrics {
+		observeCodeVersions(p.ID, versions, intervalScale(start, end))
+	}
+	ids, exposed := observeOccurrences(p.ID, occs, start, end)
+	st.Watermarks[p.ID] = end.Unix()
+
+	occurred := make(map[int]bool)
+	for _, id := range ids {
+		occurred[id] = true
+	}
+	// items could be resolved or muted without any occurrence, refresh them too
+	ids = append(ids, trackedItems(p.ID, occurred, time.Now().Unix())...)
+
+	pid := fmt.Sprintf("%d", p.ID)
+	itemsFetched.WithLabelValues(pid).Set(0)
+	// the items remembered keep the project recency even if nothing occurred
+	defer projectRecency.update(p.ID)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	items, err := accountOf(p.ID).ListItemsWithIDs(token, ids)
+	if err != nil {
+		dropToken(p, token, err)
+		return &stageError{stageItems, "ListItemsWithIDs", err}
+	}
+	itemsFetched.WithLabelValues(pid).Set(float64(len(items)))
+
+	now := time.Now().Unix()
+	for _, item := range items {
+		if updateItem(item, occurred[item.ID], start.Unix(), now) == transitionNew && CodeVersionMetrics {
+			observeNewItem(p.ID, token, item)
+		}
+		if !exposed[item.ID] {
 			continue
 		}
 
-		for _, item := range items {
-			// set item_status
-			itemStatus.WithLabelValues(
-				fmt.Sprintf("%d", item.ID),        /* item_id */
-				item.Title,                        /* title */
-				fmt.Sprintf("%d", item.ProjectID), /* project_id */
-				fmt.Sprintf("%d", item.CounterID), /* counter_id */
-				item.Environment,                  /* environment */
-				item.Platform,                     /* platform */
-				item.Framework,                    /* framework */
-				item.Hash,                         /* hash */
-				item.Status,                       /* status */
-				item.Level,                        /* level */
-			).Set(1)
+		id := fmt.Sprintf("%d", item.ID)
+
+		// set item_status
+		if l, ok := itemStatusLabels.of(prometheus.Labels{
+			"item_id":     id,
+			"title":       normalizeTitle(item.Title),
+			"project_id":  fmt.Sprintf("%d", item.ProjectID),
+			"counter_id":  fmt.Sprintf("%d", item.CounterID),
+			"environment": item.Environment,
+			"platform":    item.Platform,
+			"framework":   item.Framework,
+			"hash":        item.Hash,
+			"status":      item.Status,
+			"level":       item.Level,
+		}); ok {
+			itemStatus.With(l).Set(1)
+		}
+
+		if l, ok := occurrencesLabels.of(prometheus.Labels{
+			"project_id": pid,
+			"item_id":    id,
+		}); ok {
+			occurrences.With(l).Set(float64(item.TotalOccurrences))
+		}
+
+		if l, ok := firstOccurrenceLabels.of(prometheus.Labels{
+			"project_id": pid,
+			"item_id":    id,
+		}); ok {
+			itemFirstOccurrence.With(l).Set(float64(item.FirstOccurrenceTimestamp))
+		}
 
-			occurrences.WithLabelValues(
-				fmt.Sprintf("%d", p.ID),    /* project_id */
-				fmt.Sprintf("%d", item.ID), /* item_id */
-			).Set(float64(item.TotalOccurrences))
+		if l, ok := lastOccurrenceLabels.of(prometheus.Labels{
+			"project_id": pid,
+			"item_id":    id,
+		}); ok {
+			itemLastOccurrence.With(l).Set(float64(item.LastOccurrenceTimestamp))
 		}
 	}
 
 	return nil
 }
+
+// itemEnv - occurrences of an item are grouped by environment
+type itemEnv struct {
+	ItemID      int
+	Environment string
+}
+
+// observeOccurrences - update the occurrence metrics of the project, returns IDs of the items occurred,
+// up to MaxItems of them, and the items exposed. Items out of the top TopItems or MaxItems of the project
+// are folded into the "other" item, so no occurrence is lost from the counters.
+func observeOccurrences(projectID int, occs []rollbar.ItemOccurrence, start, end time.Time) ([]int, map[int]bool) {
+	totals := make(map[itemEnv]int64)
+	for _, occ := range occs {
+		if !matchEnvironment(projectID, occ.Environment) {
+			logrus.Debugf("skip item %d in environment %s", occ.ItemID, occ.Environment)
+			continue
+		}
+		// with granularity there is one row per time point, sum them up for the window
+		totals[itemEnv{occ.ItemID, occ.Environment}] += occ.OccurrenceCount
+	}
+	s := settingsOf(projectID)
+	top := topItems(totals, minPositive(s.TopItems, s.MaxItems))
+	if AnomalyDetection {
+		observeItemAnomalies(projectID, totals, top, end.Sub(start))
+	}
+
+	pid := fmt.Sprintf("%d", projectID)
+	scale := intervalScale(start, end)
+	labelsOf := func(itemID int, env string) prometheus.Labels {
+		id := otherItemID
+		if top[itemID] {
+			id = fmt.Sprintf("%d", itemID)
+		}
+		return prometheus.Labels{
+			"project_id":  pid,
+			"item_id":     id,
+			"environment": env,
+		}
+	}
+
+	// fold the items out of top N
+	folded := make(map[string]prometheus.Labels)
+	sums := make(map[string]int64)
+	for key, total := range totals {
+		labels := labelsOf(key.ItemID, key.Environment)
+		k := seriesKey(labels)
+		folded[k] = labels
+		sums[k] += total
+	}
+	for k, labels := range folded {
+		if l, ok := histogramLabels.of(labels); ok {
+			occurenceHistorigram.With(l).Observe(float64(sums[k]) * scale)
+		}
+		if l, ok := scrapedLabels.of(labels); ok {
+			occurrencesScraped.Add(l, float64(sums[k]))
+		}
+	}
+
+	if MinuteResolution {
+		// the window ends at a minute boundary, see window
+		lastMinute := end.Truncate(time.Minute).Add(-time.Minute)
+		occurrencesLastMinute.DeletePartialMatch(prometheus.Labels{"project_id": pid})
+		minutes := make(map[string]*series)
+		for _, occ := range occs {
+			if !matchEnvironment(projectID, occ.Environment) || occ.Time.After(lastMinute) {
+				continue
+			}
+			l, ok := perMinuteLabels.of(labelsOf(occ.ItemID, occ.Environment))
+			if !ok {
+				continue
+			}
+			k := seriesKey(l)
+			s, ok := minutes[k]
+			if !ok {
+				s = &series{Labels: l}
+				minutes[k] = s
+			}
+			s.Points = append(s.Points, point{Time: occ.Time, Value: float64(occ.OccurrenceCount)})
+		}
+		ss := make([]series, 0, len(minutes))
+		for _, s := range minutes {
+			ss = append(ss, *s)
+		}
+		occurrencesPerMinute.Replace(map[string]string{"project_id": pid}, ss)
+
+		for _, labels := range folded {
+			if l, ok := lastMinuteLabels.of(labels); ok {
+				occurrencesLastMinute.With(l).Add(0)
+			}
+		}
+		for _, occ := range occs {
+			if !matchEnvironment(projectID, occ.Environment) || !occ.Time.Equal(lastMinute) {
+				continue
+			}
+			if l, ok := lastMinuteLabels.of(labelsOf(occ.ItemID, occ.Environment)); ok {
+				occurrencesLastMinute.With(l).Add(float64(occ.OccurrenceCount))
+			}
+		}
+	}
+
+	// the items out of the top N are still tracked, only their series are folded
+	tracked := topItems(totals, s.MaxItems)
+	ids := make([]int, 0, len(tracked))
+	for id := range tracked {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, top
+}
+
+// minPositive - the smaller of the positive ones, 0 if neither is
+func minPositive(a, b int) int {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// matchEnvironment - whether the environment passes the include/exclude filters of the project
+func matchEnvironment(projectID int, env string) bool {
+	return settingsOf(projectID).matchEnvironment(env)
+}
+
+// matchEnvironment - whether the environment passes the include/exclude filters of the settings
+func (s projectSettings) matchEnvironment(env string) bool {
+	return s.IncludeEnvironments.MatchString(env) && !s.ExcludeEnvironments.MatchString(env)
+}
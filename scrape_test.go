@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+)
+
+func Test_Window(t *testing.T) {
+	reset(t)
+	defer func(m bool, i time.Duration) { MinuteResolution, ScrapeInterval = m, i }(MinuteResolution, ScrapeInterval)
+	ScrapeInterval = 2 * time.Minute
+
+	now := time.Date(2022, 1, 1, 10, 5, 30, 0, time.UTC)
+	for _, c := range []struct {
+		Minute     bool
+		Watermark  time.Time
+		Start, End time.Time
+	}{
+		{false, time.Time{}, now.Add(-2 * time.Minute), now},
+		{false, now.Add(-time.Minute), now.Add(-time.Minute), now},
+		{false, now.Add(-maxCatchUp - time.Minute), now.Add(-2 * time.Minute), now},
+		{true, time.Time{}, now.Add(-150 * time.Second), now.Add(-30 * time.Second)},
+		{true, now.Add(-90 * time.Second), now.Add(-90 * time.Second), now.Add(-30 * time.Second)},
+		// the watermark of the last window is the start of this minute, the window is empty
+		{true, now.Add(-30 * time.Second), now.Add(-30 * time.Second), now.Add(-30 * time.Second)},
+	} {
+		MinuteResolution = c.Minute
+		st.Watermarks = map[int]int64{}
+		if !c.Watermark.IsZero() {
+			st.Watermarks[1] = c.Watermark.Unix()
+		}
+		start, end := window(1, now)
+		equals(t, c.Start, start.UTC())
+		equals(t, c.End, end.UTC())
+	}
+}
+
+func Test_WindowMinuteResolutionAcrossMinutes(t *testing.T) {
+	reset(t)
+	defer func(m, i bool, s time.Duration) {
+		MinuteResolution, ItemMetrics, ScrapeInterval = m, i, s
+	}(MinuteResolution, ItemMetrics, ScrapeInterval)
+	MinuteResolution, ItemMetrics, ScrapeInterval = true, true, 2*time.Minute
+
+	// an occurrence every 20 seconds, answered per minute as the API does with the minute granularity
+	base := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	query := func(start, end time.Time) []rollbar.ItemOccurrence {
+		counts := map[time.Time]int64{}
+		for t := base; t.Before(base.Add(10 * time.Minute)); t = t.Add(20 * time.Second) {
+			if !t.Before(start) && t.Before(end) {
+				counts[t.Truncate(time.Minute)]++
+			}
+		}
+		occs := []rollbar.ItemOccurrence{}
+		for minute, n := range counts {
+			occs = append(occs, rollbar.ItemOccurrence{ItemID: 1, Environment: "production", Time: minute, OccurrenceCount: n})
+		}
+		return occs
+	}
+
+	perMinute := map[time.Time]float64{}
+	for _, now := range []time.Time{base.Add(5*time.Minute + 30*time.Second), base.Add(7*time.Minute + 10*time.Second)} {
+		start, end := window(1, now)
+		observeOccurrences(1, query(start, end), start, end)
+		st.Watermarks[1] = end.Unix()
+		for _, m := range occurrencesPerMinute.family().GetMetric() {
+			perMinute[time.UnixMilli(m.GetTimestampMs()).UTC()] += m.GetGauge().GetValue()
+		}
+	}
+
+	// every minute of both windows is complete, including the one in progress at the first scrape
+	equals(t, map[time.Time]float64{
+		base.Add(3 * time.Minute): 3,
+		base.Add(4 * time.Minute): 3,
+		base.Add(5 * time.Minute): 3,
+		base.Add(6 * time.Minute): 3,
+	}, perMinute)
+	samples := occurrencesScraped.samples()
+	equals(t, 1, len(samples))
+	equals(t, 12.0, samples[0].Value)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/sirupsen/logrus"
+)
+
+// secret - a token from an environment variable, or from a file which is watched for rotation
+type secret struct {
+	// Name - how the token is called in the logs
+	Name string
+	// Label - the token label of the reload counter
+	Label string
+	// Set - put the value in effect, the current one is kept on error
+	Set func(value string) error
+
+	env string
+	// path - the file of the token, empty if from the environment variable
+	path string
+	// required - the token can't be empty
+	required bool
+	value    string
+}
+
+// setToken - Set of a secret with an account token
+func setToken(set func(token string)) func(string) error {
+	return func(token string) error {
+		set(token)
+		return nil
+	}
+}
+
+// secrets - the tokens in effect, set by loadSecrets
+var secrets = []*secret{}
+
+// envSecrets - the tokens of the account from $ROLLBAR_ACCOUNT_READ_TOKEN and $ROLLBAR_ACCOUNT_WRITE_TOKEN,
+// or the files of their *_FILE variables. The write token is left out in read only mode.
+func envSecrets(a *rollbar.Account, readOnly bool) []*secret {
+	ss := make([]*secret, 0, 2)
+	for _, s := range []struct {
+		Env   string
+		Label string
+		Set   func(string)
+		Write bool
+	}{
+		{"ROLLBAR_ACCOUNT_READ_TOKEN", "account_read", a.SetReadAccessToken, false},
+		{"ROLLBAR_ACCOUNT_WRITE_TOKEN", "account_write", a.SetWriteAccessToken, true},
+	} {
+		path := os.Getenv(s.Env + "_FILE")
+		name := "$" + s.Env
+		if path != "" {
+			name += "_FILE"
+		}
+		if s.Write && readOnly {
+			if path != "" || os.Getenv(s.Env) != "" {
+				logrus.Warnf("%s is ignored in read only mode", name)
+			}
+			a.SetWriteAccessToken("")
+			continue
+		}
+		ss = append(ss, &secret{
+			Name:  name,
+			Label: s.Label,
+			Set:   setToken(s.Set),
+			env:   s.Env,
+			path:  path,
+		})
+	}
+	return ss
+}
+
+// secretPollInterval - how often the token files are checked for rotation
+const secretPollInterval = 30 * time.Second
+
+// readSecretFile - the content of the file, surrounding whitespaces trimmed
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(b))
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return value, nil
+}
+
+// loadSecrets - set the tokens from their environment variables or files
+func loadSecrets(ss []*secret) error {
+	for _, s := range ss {
+		if s.path == "" {
+			s.value = os.Getenv(s.env)
+			if s.required && s.value == "" {
+				return fmt.Errorf("%s: $%s is empty", s.Name, s.env)
+			}
+			if err := s.Set(s.value); err != nil {
+				return fmt.Errorf("%s: %v", s.Name, err)
+			}
+			continue
+		}
+		if s.env != "" && os.Getenv(s.env) != "" {
+			return fmt.Errorf("$%s and $%s_FILE are both set, only one is allowed", s.env, s.env)
+		}
+		value, err := readSecretFile(s.path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.Name, err)
+		}
+		if err := s.Set(value); err != nil {
+			return fmt.Errorf("%s: %v", s.Name, err)
+		}
+		s.value = value
+		tokenReloads.WithLabelValues(s.Label, "success")
+		tokenReloads.WithLabelValues(s.Label, "failure")
+	}
+	secrets = ss
+	return nil
+}
+
+// reload - read the file of the secret again, and switch to it if it changed
+func (s *secret) reload() error {
+	value, err := readSecretFile(s.path)
+	if err != nil {
+		return err
+	}
+	if value == s.value {
+		return nil
+	}
+	if err := s.Set(value); err != nil {
+		return err
+	}
+	s.value = value
+	logrus.Infof("%s %s changed, token rotated", s.Name, s.path)
+	tokenReloads.WithLabelValues(s.Label, "success").Inc()
+	return nil
+}
+
+// watchSecrets - reload the token files on SIGHUP or periodically, the requests in flight keep the old token
+func watchSecrets() {
+	watched := make([]*secret, 0, len(secrets))
+	for _, s := range secrets {
+		if s.path != "" {
+			watched = append(watched, s)
+		}
+	}
+	if len(watched) == 0 {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(secretPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+		}
+		for _, s := range watched {
+			if err := s.reload(); err != nil {
+				// a rotation may replace the file in steps, keep the current token and retry later
+				logrus.Errorf("reload %s failed, keep the current token - %v", s.Name, err)
+				tokenReloads.WithLabelValues(s.Label, "failure").Inc()
+			}
+		}
+	}
+}
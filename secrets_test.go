@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// tokenSecret - a secret of a token file, the token set is kept in *token
+func tokenSecret(path string, token *string) *secret {
+	return &secret{
+		Name:  "test token",
+		Label: "test",
+		Set: func(value string) error {
+			if value == "rejected" {
+				return errors.New("rejected")
+			}
+			*token = value
+			return nil
+		},
+		path: path,
+	}
+}
+
+func Test_LoadSecrets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	ok(t, os.WriteFile(path, []byte(" a\n"), 0o600))
+
+	token := ""
+	ok(t, loadSecrets([]*secret{tokenSecret(path, &token)}))
+	equals(t, "a", token)
+
+	for _, c := range []struct {
+		Name   string
+		Secret *secret
+		Setup  func()
+		Error  string
+	}{
+		{"missing file", tokenSecret(filepath.Join(dir, "missing"), &token), func() {}, "no such file"},
+		{"empty file", tokenSecret(path, &token), func() { ok(t, os.WriteFile(path, []byte("\n"), 0o600)) }, "is empty"},
+		{"rejected", tokenSecret(path, &token), func() { ok(t, os.WriteFile(path, []byte("rejected"), 0o600)) }, "rejected"},
+		{"both set", &secret{Name: "$T_FILE", env: "T", path: path}, func() { t.Setenv("T", "b") }, "only one is allowed"},
+		{"required", &secret{Name: "$T", env: "T", required: true}, func() { t.Setenv("T", "") }, "is empty"},
+	} {
+		c.Setup()
+		err := loadSecrets([]*secret{c.Secret})
+		assert(t, err != nil && strings.Contains(err.Error(), c.Error), "%s: expected %q, got %v", c.Name, c.Error, err)
+	}
+}
+
+func Test_SecretReload(t *testing.T) {
+	reset(t)
+	tokenReloads.Reset()
+	path := filepath.Join(t.TempDir(), "token")
+	ok(t, os.WriteFile(path, []byte("a"), 0o600))
+	token := ""
+	s := tokenSecret(path, &token)
+	ok(t, loadSecrets([]*secret{s}))
+	success := tokenReloads.WithLabelValues("test", "success")
+
+	// unchanged
+	ok(t, s.reload())
+	equals(t, 0.0, testutil.ToFloat64(success))
+
+	// rotated
+	ok(t, os.WriteFile(path, []byte("b\n"), 0o600))
+	ok(t, s.reload())
+	equals(t, "b", token)
+	equals(t, 1.0, testutil.ToFloat64(success))
+
+	// the current token is kept on failure
+	for _, content := range []string{"", "rejected"} {
+		ok(t, os.WriteFile(path, []byte(content), 0o600))
+		assert(t, s.reload() != nil, "%q is not rejected", content)
+		equals(t, "b", token)
+	}
+	ok(t, os.Remove(path))
+	assert(t, s.reload() != nil, "a missing file is not rejected")
+	equals(t, "b", token)
+	equals(t, 1.0, testutil.ToFloat64(success))
+
+	// a rotation after a failure goes on
+	ok(t, os.WriteFile(path, []byte("c"), 0o600))
+	ok(t, s.reload())
+	equals(t, "c", token)
+}
+
+func Test_EnvSecrets(t *testing.T) {
+	a := defaultAccount.Account
+	defer func(read, write string) {
+		a.SetReadAccessToken(read)
+		a.SetWriteAccessToken(write)
+	}(a.ReadAccessToken(), a.WriteAccessToken())
+	path := filepath.Join(t.TempDir(), "read")
+	ok(t, os.WriteFile(path, []byte("r"), 0o600))
+	t.Setenv("ROLLBAR_ACCOUNT_READ_TOKEN_FILE", path)
+	t.Setenv("ROLLBAR_ACCOUNT_WRITE_TOKEN", "w")
+
+	ss := envSecrets(a, false)
+	equals(t, 2, len(ss))
+	equals(t, "$ROLLBAR_ACCOUNT_READ_TOKEN_FILE", ss[0].Name)
+	ok(t, loadSecrets(ss))
+	equals(t, "r", a.ReadAccessToken())
+	equals(t, "w", a.WriteAccessToken())
+
+	// the write token is left out in read only mode
+	ss = envSecrets(a, true)
+	equals(t, 1, len(ss))
+	equals(t, "", a.WriteAccessToken())
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sync"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Actions of the selection rules
+const (
+	actionInclude = "include"
+	actionExclude = "exclude"
+)
+
+// SelectionRule - includes or excludes the projects matching all of its conditions,
+// a rule without any condition matches every project
+type SelectionRule struct {
+	Action     string           `yaml:"action"`
+	IDs        []int            `yaml:"ids"`
+	AccountIDs []int            `yaml:"account_ids"`
+	Names      []string         `yaml:"names"`
+	NameRegex  string           `yaml:"name_regex"`
+	Statuses   []rollbar.Status `yaml:"statuses"`
+
+	// compiled by validate
+	nameRegex *regexp.Regexp
+}
+
+func validateSelectionRules(rules []SelectionRule) error {
+	for i := range rules {
+		r := &rules[i]
+		if r.Action != actionInclude && r.Action != actionExclude {
+			return fmt.Errorf("selection[%d]: action %q should be %s or %s", i, r.Action, actionInclude, actionExclude)
+		}
+		for _, name := range r.Names {
+			if _, err := path.Match(name, ""); err != nil {
+				return fmt.Errorf("selection[%d]: invalid glob %q", i, name)
+			}
+		}
+		if r.NameRegex != "" {
+			var err error
+			if r.nameRegex, err = compileRegex(fmt.Sprintf("selection[%d]: name_regex", i), r.NameRegex); err != nil {
+				return err
+			}
+		}
+		for _, status := range r.Statuses {
+			if status != rollbar.StatusEnabled && status != rollbar.StatusDisabled {
+				return fmt.Errorf("selection[%d]: status %q should be %s or %s", i, status, rollbar.StatusEnabled, rollbar.StatusDisabled)
+			}
+		}
+	}
+	return nil
+}
+
+// match - whether the project meets all conditions of the rule
+func (r SelectionRule) match(p rollbar.Project) bool {
+	if len(r.IDs) > 0 && !containsInt(r.IDs, p.ID) {
+		return false
+	}
+	if len(r.AccountIDs) > 0 && !containsInt(r.AccountIDs, p.AccountID) {
+		return false
+	}
+	if len(r.Names) > 0 {
+		matched := false
+		for _, name := range r.Names {
+			if ok, _ := path.Match(name, p.Name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if r.nameRegex != nil && !r.nameRegex.MatchString(p.Name) {
+		return false
+	}
+	if len(r.Statuses) > 0 && !containsStatus(r.Statuses, p.Status) {
+		return false
+	}
+	return true
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// selection - whether the project should be scraped and why. In order of precedence:
+//  1. the projects asked for on the command line
+//  2. skip of the project overrides, then the project filters of its account
+//  3. the first matching selection rule, an include rule only matches a disabled
+//     project if it asks for the disabled status explicitly, else the next rules are tried
+//  4. with include rules, projects matching none of them are skipped, as disabled
+//     if an include rule matched but for the status
+//  5. INCLUDE_PROJECTS_REGEX, EXCLUDE_PROJECTS_REGEX and the disabled status
+func selection(p rollbar.Project) (bool, string) {
+	if len(onlyProjects) > 0 && !onlyProjects[p.Name] && !onlyProjects[fmt.Sprintf("%d", p.ID)] {
+		return false, "not asked for"
+	}
+	if settingsOf(p.ID).Skip {
+		return false, "skipped by its config"
+	}
+	if a := accountOf(p.ID); a.includeProjects != nil && !a.includeProjects.MatchString(p.Name) {
+		return false, fmt.Sprintf("not matching include_projects_regex of account %s", a.Name)
+	} else if a.excludeProjects != nil && a.excludeProjects.MatchString(p.Name) {
+		return false, fmt.Sprintf("matching exclude_projects_regex of account %s", a.Name)
+	}
+	hasInclude, disabled := false, false
+	for i, r := range SelectionRules {
+		hasInclude = hasInclude || r.Action == actionInclude
+		if !r.match(p) {
+			continue
+		}
+		if r.Action == actionExclude {
+			return false, fmt.Sprintf("excluded by selection[%d]", i)
+		}
+		if p.Status == rollbar.StatusDisabled && !containsStatus(r.Statuses, rollbar.StatusDisabled) {
+			disabled = true
+			continue
+		}
+		return true, fmt.Sprintf("included by selection[%d]", i)
+	}
+	if disabled {
+		return false, "disabled"
+	}
+	if hasInclude {
+		return false, "no selection rule matched"
+	}
+	if !IncludeProjectsRegex.MatchString(p.Name) {
+		return false, "not matching include_projects_regex"
+	}
+	if ExcludeProjectsRegex.MatchString(p.Name) {
+		return false, "matching exclude_projects_regex"
+	}
+	if p.Status == rollbar.StatusDisabled {
+		return false, "disabled"
+	}
+	return true, "selected by default"
+}
+
+func containsStatus(statuses []rollbar.Status, status rollbar.Status) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+var projectSelection = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "exporter_project_selected",
+	Help: "This is 1 if a project is scraped, 0 if skipped, reason tells why",
+}, []string{
+	"project_id",
+	"name",
+	"reason",
+})
+
+// selections - project ID to the last selection reason, to log only the changes
+var (
+	selectionsMu sync.Mutex
+	selections   = map[int]string{}
+)
+
+// selectProject - whether the project should be scraped, logs the decision once it changes
+func selectProject(p rollbar.Project) bool {
+	selected, reason := selection(p)
+
+	v := 0.0
+	if selected {
+		v = 1
+	}
+	projectSelection.WithLabelValues(fmt.Sprintf("%d", p.ID), p.Name, reason).Set(v)
+
+	selectionsMu.Lock()
+	defer selectionsMu.Unlock()
+	if selections[p.ID] == reason {
+		return selected
+	}
+	selections[p.ID] = reason
+	if selected {
+		logrus.Infof("select project [%d]%s - %s", p.ID, p.Name, reason)
+	} else {
+		logrus.Infof("skip project [%d]%s - %s", p.ID, p.Name, reason)
+	}
+	return selected
+}
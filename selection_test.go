@@ -0,0 +1,123 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+)
+
+func Test_SelectionRuleMatch(t *testing.T) {
+	p := rollbar.Project{ID: 1, AccountID: 10, Name: "checkout-staging", Status: rollbar.StatusEnabled}
+	for _, c := range []struct {
+		Name  string
+		Rule  SelectionRule
+		Match bool
+	}{
+		{"no condition", SelectionRule{}, true},
+		{"id", SelectionRule{IDs: []int{2, 1}}, true},
+		{"other id", SelectionRule{IDs: []int{2}}, false},
+		{"account", SelectionRule{AccountIDs: []int{10}}, true},
+		{"other account", SelectionRule{AccountIDs: []int{11}}, false},
+		{"glob", SelectionRule{Names: []string{"web", "*-staging"}}, true},
+		{"other glob", SelectionRule{Names: []string{"*-production"}}, false},
+		{"regex", SelectionRule{NameRegex: "^checkout"}, true},
+		{"other regex", SelectionRule{NameRegex: "^web"}, false},
+		{"status", SelectionRule{Statuses: []rollbar.Status{rollbar.StatusEnabled}}, true},
+		{"other status", SelectionRule{Statuses: []rollbar.Status{rollbar.StatusDisabled}}, false},
+		{"all conditions", SelectionRule{IDs: []int{1}, AccountIDs: []int{10}, Names: []string{"checkout-*"}}, true},
+		{"one condition fails", SelectionRule{IDs: []int{1}, AccountIDs: []int{11}, Names: []string{"checkout-*"}}, false},
+	} {
+		c.Rule.Action = actionInclude
+		rules := []SelectionRule{c.Rule}
+		ok(t, validateSelectionRules(rules))
+		assert(t, rules[0].match(p) == c.Match, "%s: expected match %v", c.Name, c.Match)
+	}
+}
+
+func Test_ValidateSelectionRules(t *testing.T) {
+	for _, c := range []struct {
+		Rule  SelectionRule
+		Valid bool
+	}{
+		{SelectionRule{Action: actionInclude}, true},
+		{SelectionRule{Action: actionExclude, Statuses: []rollbar.Status{rollbar.StatusDisabled}}, true},
+		{SelectionRule{Action: "skip"}, false},
+		{SelectionRule{Action: actionInclude, Names: []string{"[a"}}, false},
+		{SelectionRule{Action: actionInclude, NameRegex: "("}, false},
+		{SelectionRule{Action: actionInclude, Statuses: []rollbar.Status{"deleted"}}, false},
+	} {
+		err := validateSelectionRules([]SelectionRule{c.Rule})
+		assert(t, (err == nil) == c.Valid, "%+v: expected valid %v, got %v", c.Rule, c.Valid, err)
+	}
+}
+
+func Test_Selection(t *testing.T) {
+	reset(t)
+	defer func(rules []SelectionRule, include, exclude *regexp.Regexp) {
+		SelectionRules, IncludeProjectsRegex, ExcludeProjectsRegex = rules, include, exclude
+	}(SelectionRules, IncludeProjectsRegex, ExcludeProjectsRegex)
+	IncludeProjectsRegex, ExcludeProjectsRegex = regexp.MustCompile(""), regexp.MustCompile("^$")
+
+	enabled := rollbar.Project{ID: 1, AccountID: 10, Name: "checkout", Status: rollbar.StatusEnabled}
+	disabled := rollbar.Project{ID: 2, AccountID: 10, Name: "legacy", Status: rollbar.StatusDisabled}
+	include := func(r SelectionRule) SelectionRule { r.Action = actionInclude; return r }
+	exclude := func(r SelectionRule) SelectionRule { r.Action = actionExclude; return r }
+	for _, c := range []struct {
+		Name     string
+		Rules    []SelectionRule
+		Project  rollbar.Project
+		Selected bool
+		Reason   string
+	}{
+		{"default", nil, enabled, true, "selected by default"},
+		{"disabled by default", nil, disabled, false, "disabled"},
+		{"excluded", []SelectionRule{exclude(SelectionRule{IDs: []int{1}})}, enabled, false, "excluded by selection[0]"},
+		{"included", []SelectionRule{include(SelectionRule{IDs: []int{1}})}, enabled, true, "included by selection[0]"},
+		{"first match wins", []SelectionRule{
+			include(SelectionRule{Names: []string{"check*"}}),
+			exclude(SelectionRule{}),
+		}, enabled, true, "included by selection[0]"},
+		{"no include matched", []SelectionRule{include(SelectionRule{IDs: []int{3}})}, enabled, false, "no selection rule matched"},
+		{"only excludes", []SelectionRule{exclude(SelectionRule{IDs: []int{3}})}, enabled, true, "selected by default"},
+		{"disabled included", []SelectionRule{include(SelectionRule{AccountIDs: []int{10}})}, disabled, false, "disabled"},
+		{"disabled included by status", []SelectionRule{
+			include(SelectionRule{Statuses: []rollbar.Status{rollbar.StatusDisabled}}),
+		}, disabled, true, "included by selection[0]"},
+		{"disabled included by a later rule", []SelectionRule{
+			include(SelectionRule{AccountIDs: []int{10}}),
+			include(SelectionRule{IDs: []int{2}, Statuses: []rollbar.Status{rollbar.StatusDisabled}}),
+		}, disabled, true, "included by selection[1]"},
+		{"disabled excluded by a later rule", []SelectionRule{
+			include(SelectionRule{AccountIDs: []int{10}}),
+			exclude(SelectionRule{IDs: []int{2}}),
+		}, disabled, false, "excluded by selection[1]"},
+	} {
+		ok(t, validateSelectionRules(c.Rules))
+		SelectionRules = c.Rules
+		selected, reason := selection(c.Project)
+		equals(t, c.Name+": "+c.Reason, c.Name+": "+reason)
+		equals(t, c.Selected, selected)
+	}
+}
+
+func Test_SelectionPrecedence(t *testing.T) {
+	reset(t)
+	defer func(rules []SelectionRule, overrides []ProjectConfig) {
+		SelectionRules, ProjectOverrides = rules, overrides
+	}(SelectionRules, ProjectOverrides)
+	p := rollbar.Project{ID: 1, Name: "checkout", Status: rollbar.StatusEnabled}
+	SelectionRules = []SelectionRule{{Action: actionInclude}}
+
+	onlyProjects = map[string]bool{"web": true}
+	selected, reason := selection(p)
+	equals(t, false, selected)
+	equals(t, "not asked for", reason)
+
+	onlyProjects = map[string]bool{"1": true}
+	ProjectOverrides = []ProjectConfig{{Name: "checkout", Skip: true}}
+	resolveOverrides([]rollbar.Project{p})
+	selected, reason = selection(p)
+	equals(t, false, selected)
+	equals(t, "skipped by its config", reason)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// SLO - an objective of "fewer than Objective occurrences per Window"
+// over the projects, environments and levels
+type SLO struct {
+	Name         string       `yaml:"name"`
+	ProjectIDs   []int        `yaml:"project_ids"`
+	Projects     []string     `yaml:"projects"`
+	Environments []string     `yaml:"environments"`
+	Levels       []string     `yaml:"levels"`
+	Window       duration     `yaml:"window"`
+	Objective    float64      `yaml:"objective"`
+	BurnWindows  []burnWindow `yaml:"burn_rate_windows"`
+}
+
+// burnWindow - a burn rate window, labelled as configured, e.g. 5m rather than 5m0s
+type burnWindow struct {
+	Duration duration
+	Label    string
+}
+
+func (w *burnWindow) UnmarshalYAML(value *yaml.Node) error {
+	if err := w.Duration.UnmarshalYAML(value); err != nil {
+		return err
+	}
+	w.Label = value.Value
+	return nil
+}
+
+func (w burnWindow) MarshalYAML() (any, error) {
+	return w.Label, nil
+}
+
+// defaultBurnWindows - the short and long windows of multi-window burn rate alerts
+var defaultBurnWindows = []burnWindow{
+	{duration(5 * time.Minute), "5m"},
+	{duration(time.Hour), "1h"},
+	{duration(6 * time.Hour), "6h"},
+}
+
+type sloConfig struct {
+	SLOs []SLO `yaml:"slos"`
+}
+
+// loadSLOs - read the SLO definitions from the YAML file
+func loadSLOs(path string) ([]SLO, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c sloConfig
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := validateSLOs(c.SLOs); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c.SLOs, nil
+}
+
+func validateSLOs(slos []SLO) error {
+	names := make(map[string]bool)
+	for i := range slos {
+		s := &slos[i]
+		if s.Name == "" {
+			return fmt.Errorf("slos[%d]: name is required", i)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("slos[%d]: duplicated name %s", i, s.Name)
+		}
+		names[s.Name] = true
+		if len(s.ProjectIDs) == 0 && len(s.Projects) == 0 {
+			return fmt.Errorf("slo %s: project_ids or projects is required", s.Name)
+		}
+		if s.Window < duration(time.Minute) {
+			return fmt.Errorf("slo %s: window should be at least 1m", s.Name)
+		}
+		if s.Objective <= 0 {
+			return fmt.Errorf("slo %s: objective should be positive", s.Name)
+		}
+		if len(s.BurnWindows) == 0 {
+			s.BurnWindows = defaultBurnWindows
+		}
+		for _, w := range s.BurnWindows {
+			if w.Duration < duration(time.Minute) {
+				return fmt.Errorf("slo %s: burn rate window should be at least 1m", s.Name)
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	sloObjective = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slo_objective",
+		Help: "This is the max occurrences allowed per SLO window",
+	}, []string{
+		"slo",
+	})
+
+	sloOccurrences = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slo_occurrences",
+		Help: "This is the occurrences matching the SLO in its window",
+	}, []string{
+		"slo",
+	})
+
+	sloBudgetRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slo_error_budget_remaining",
+		Help: "This is the ratio of the error budget left in the SLO window, negative when exhausted",
+	}, []string{
+		"slo",
+	})
+
+	sloBurnRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slo_burn_rate",
+		Help: "This is how fast the error budget is consumed in a window, 1 exhausts it exactly at the end of the SLO window",
+	}, []string{
+		"slo",
+		"window",
+	})
+)
+
+func registerSLOMetrics() {
+	registry.MustRegister(sloObjective)
+	registry.MustRegister(sloOccurrences)
+	registry.MustRegister(sloBudgetRemaining)
+	registry.MustRegister(sloBurnRate)
+}
+
+// sloProjects - the projects an SLO covers
+func sloProjects(s SLO, ps []rollbar.Project) []rollbar.Project {
+	ids := make(map[int]bool)
+	for _, id := range s.ProjectIDs {
+		ids[id] = true
+	}
+	names := make(map[string]bool)
+	for _, name := range s.Projects {
+		names[name] = true
+	}
+	result := make([]rollbar.Project, 0)
+	for _, p := range ps {
+		if ids[p.ID] || names[p.Name] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// match - whether the row counts for the SLO
+func (s SLO) match(row rollbar.OccurrenceRow) bool {
+	return contains(s.Environments, row.String(rollbar.FieldEnvironment)) &&
+		contains(s.Levels, row.String(rollbar.FieldItemLevel))
+}
+
+// contains - whether v is in values, any value is if values is empty
+func contains(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// burnRate - how fast the occurrences in w consume the error budget of the SLO,
+// 1 is the rate exhausting it exactly at the end of the SLO window
+func burnRate(s SLO, occurred float64, w duration) float64 {
+	allowed := s.Objective / time.Duration(s.Window).Seconds()
+	return occurred / time.Duration(w).Seconds() / allowed
+}
+
+// evaluateSLOs - query the occurrences of every SLO window and update the SLO metrics,
+// ps are the projects selected, the SLOs don't cover the others
+func evaluateSLOs(ps []rollbar.Project, now time.Time) {
+	type query struct {
+		ProjectID int
+		Window    duration
+	}
+	// the SLOs of the same project and window share the query, the errors are
+	// counted here by the account of the project
+	cache := make(map[query][]rollbar.OccurrenceRow)
+	count := func(s SLO, w duration) (float64, error) {
+		total := 0.0
+		for _, p := range sloProjects(s, ps) {
+			q := query{p.ID, w}
+			rows, ok := cache[q]
+			if !ok {
+				token, err := projectToken(p)
+				if err != nil {
+					countScrapeError(accountOf(p.ID), stageToken)
+					return 0, &stageError{stageToken, "GetOrCreateProjectReadToken", err}
+				}
+				params := rollbar.NewOccurrencesInputRange(now.Add(-time.Duration(w)), now,
+					rollbar.FieldEnvironment, rollbar.FieldItemLevel)
+				rows, err = accountOf(p.ID).QueryOccurrences(token, params, 0)
+				if err != nil {
+					dropToken(p, token, err)
+					countScrapeError(accountOf(p.ID), stageOccurrences)
+					return 0, &stageError{stageOccurrences, "QueryOccurrences", err}
+				}
+				cache[q] = rows
+			}
+			for _, row := range rows {
+				if s.match(row) {
+					total += float64(row.Int(rollbar.FieldOccurrenceCount))
+				}
+			}
+		}
+		return total, nil
+	}
+
+	for _, s := range SLOs {
+		sloObjective.WithLabelValues(s.Name).Set(s.Objective)
+
+		occurred, err := count(s, s.Window)
+		if err != nil {
+			logrus.Errorf("%v - slo %s", err, s.Name)
+			continue
+		}
+		sloOccurrences.WithLabelValues(s.Name).Set(occurred)
+		sloBudgetRemaining.WithLabelValues(s.Name).Set(1 - occurred/s.Objective)
+
+		for _, w := range s.BurnWindows {
+			occurred, err := count(s, w.Duration)
+			if err != nil {
+				logrus.Errorf("%v - slo %s", err, s.Name)
+				continue
+			}
+			sloBurnRate.WithLabelValues(s.Name, w.Label).Set(burnRate(s, occurred, w.Duration))
+		}
+	}
+}
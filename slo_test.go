@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/yaml.v3"
+)
+
+func Test_BurnRate(t *testing.T) {
+	s := SLO{Window: duration(time.Hour), Objective: 60}
+	for _, c := range []struct {
+		Occurred float64
+		Window   time.Duration
+		Rate     float64
+	}{
+		{0, 5 * time.Minute, 0},
+		{5, 5 * time.Minute, 1},
+		{10, 5 * time.Minute, 2},
+		{60, time.Hour, 1},
+		{30, time.Hour, 0.5},
+		{720, 6 * time.Hour, 2},
+	} {
+		equals(t, c.Rate, burnRate(s, c.Occurred, duration(c.Window)))
+	}
+}
+
+func Test_BurnWindowYAML(t *testing.T) {
+	var s SLO
+	ok(t, yaml.Unmarshal([]byte("burn_rate_windows: [5m, 90m, 1h30m]"), &s))
+	equals(t, []burnWindow{
+		{duration(5 * time.Minute), "5m"},
+		{duration(90 * time.Minute), "90m"},
+		{duration(90 * time.Minute), "1h30m"},
+	}, s.BurnWindows)
+
+	b, err := yaml.Marshal(s.BurnWindows)
+	ok(t, err)
+	equals(t, "- 5m\n- 90m\n- 1h30m\n", string(b))
+
+	assert(t, yaml.Unmarshal([]byte("burn_rate_windows: [5x]"), &s) != nil, "an invalid window is rejected")
+}
+
+func Test_ValidateSLOs(t *testing.T) {
+	valid := func() SLO {
+		return SLO{Name: "checkout", Projects: []string{"app"}, Window: duration(time.Hour), Objective: 10}
+	}
+	for _, c := range []struct {
+		Name   string
+		Modify func(s *SLO)
+		Error  string
+	}{
+		{"valid", func(s *SLO) {}, ""},
+		{"no name", func(s *SLO) { s.Name = "" }, "name is required"},
+		{"no project", func(s *SLO) { s.Projects = nil }, "project_ids or projects is required"},
+		{"short window", func(s *SLO) { s.Window = duration(time.Second) }, "window should be at least 1m"},
+		{"no objective", func(s *SLO) { s.Objective = 0 }, "objective should be positive"},
+		{"short burn window", func(s *SLO) { s.BurnWindows = []burnWindow{{duration(time.Second), "1s"}} }, "burn rate window"},
+	} {
+		s := valid()
+		c.Modify(&s)
+		err := validateSLOs([]SLO{s})
+		if c.Error == "" {
+			ok(t, err)
+			continue
+		}
+		assert(t, err != nil && strings.Contains(err.Error(), c.Error), "%s: expected %q, got %v", c.Name, c.Error, err)
+	}
+
+	s := valid()
+	ok(t, validateSLOs([]SLO{s}))
+	assert(t, validateSLOs([]SLO{s, s}) != nil, "duplicated names are rejected")
+
+	slos := []SLO{valid()}
+	ok(t, validateSLOs(slos))
+	equals(t, defaultBurnWindows, slos[0].BurnWindows)
+}
+
+func Test_EvaluateSLOs(t *testing.T) {
+	reset(t)
+	defer func(slos []SLO) { SLOs = slos }(SLOs)
+	SLOs = []SLO{{
+		Name:         "checkout",
+		ProjectIDs:   []int{1},
+		Environments: []string{"production"},
+		Window:       duration(time.Hour),
+		Objective:    60,
+		BurnWindows:  []burnWindow{{duration(5 * time.Minute), "5m"}},
+	}}
+	queried := false
+	api := fakeRollbar(`[[{"field":"environment","value":"production"},{"field":"item_level","value":"error"},{"field":"occurrence_count","value":10}],` +
+		`[{"field":"environment","value":"staging"},{"field":"item_level","value":"error"},{"field":"occurrence_count","value":7}]]`)
+	serve(t, func(w http.ResponseWriter, r *http.Request) {
+		queried = true
+		api(w, r)
+	})
+
+	sloOccurrences.Reset()
+	sloBurnRate.Reset()
+
+	// a project not selected isn't covered
+	evaluateSLOs([]rollbar.Project{}, time.Now())
+	assert(t, !queried, "a project not selected is queried")
+	equals(t, 0.0, testutil.ToFloat64(sloOccurrences.WithLabelValues("checkout")))
+
+	evaluateSLOs([]rollbar.Project{{ID: 1, Name: "app"}}, time.Now())
+	equals(t, 10.0, testutil.ToFloat64(sloOccurrences.WithLabelValues("checkout")))
+	equals(t, 1-10.0/60, testutil.ToFloat64(sloBudgetRemaining.WithLabelValues("checkout")))
+	equals(t, 2.0, testutil.ToFloat64(sloBurnRate.WithLabelValues("checkout", "5m")))
+}
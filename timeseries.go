@@ -0,0 +1,206 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+// point - a single timestamped sample
+type point struct {
+	Time  time.Time
+	Value float64
+}
+
+// series - timestamped samples sharing the same labels
+type series struct {
+	Labels map[string]string
+	Points []point
+}
+
+// timeSeries - a metric family whose samples carry their own timestamps,
+// it's exposed outside of the prometheus registry since a registry only
+// accepts one sample per label set.
+type timeSeries struct {
+	Name string
+	Help string
+	Type dto.MetricType
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newTimeSeries(name, help string) *timeSeries {
+	return &timeSeries{
+		Name:   name,
+		Help:   help,
+		Type:   dto.MetricType_GAUGE,
+		series: make(map[string]*series),
+	}
+}
+
+// newCounterSeries - time series of a counter, name should end with _total
+func newCounterSeries(name, help string) *timeSeries {
+	ts := newTimeSeries(name, help)
+	ts.Type = dto.MetricType_COUNTER
+	return ts
+}
+
+// seriesKey - stable key of the label set
+func seriesKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := ""
+	for _, name := range names {
+		key += name + "=" + labels[name] + "\xff"
+	}
+	return key
+}
+
+// Add - append a sample to the series with labels
+func (ts *timeSeries) Add(labels map[string]string, t time.Time, v float64) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	key := seriesKey(labels)
+	s, ok := ts.series[key]
+	if !ok {
+		s = &series{Labels: labels}
+		ts.series[key] = s
+	}
+	s.Points = append(s.Points, point{Time: t, Value: v})
+}
+
+// Replace - drop all the series matching labels, then add the given ones
+func (ts *timeSeries) Replace(match map[string]string, ss []series) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for key, s := range ts.series {
+		if matchLabels(s.Labels, match) {
+			delete(ts.series, key)
+		}
+	}
+	for i := range ss {
+		s := ss[i]
+		ts.series[seriesKey(s.Labels)] = &s
+	}
+}
+
+func matchLabels(labels, match map[string]string) bool {
+	for name, value := range match {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// mergePoints - merge the points of the same time, by summing them up or keeping the last one
+func mergePoints(points []point, sum bool) []point {
+	values := make(map[time.Time]float64)
+	result := make([]point, 0, len(points))
+	for _, p := range points {
+		if _, ok := values[p.Time]; !ok {
+			result = append(result, point{Time: p.Time})
+		}
+		if sum {
+			values[p.Time] += p.Value
+		} else {
+			values[p.Time] = p.Value
+		}
+	}
+	for i := range result {
+		result[i].Value = values[result[i].Time]
+	}
+	return result
+}
+
+// family - snapshot of the samples as metric family, points sorted by time
+func (ts *timeSeries) family() *dto.MetricFamily {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	keys := make([]string, 0, len(ts.series))
+	for key := range ts.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	mf := &dto.MetricFamily{
+		Name: proto.String(metricName(ts.Name)),
+		Help: proto.String(ts.Help),
+		Type: ts.Type.Enum(),
+	}
+	for _, key := range keys {
+		s := ts.series[key]
+		values := make(map[string]string, len(s.Labels)+len(ConstLabels))
+		for name, value := range ConstLabels {
+			values[name] = value
+		}
+		for name, value := range s.Labels {
+			values[name] = value
+		}
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		labels := make([]*dto.LabelPair, 0, len(names))
+		for _, name := range names {
+			labels = append(labels, &dto.LabelPair{
+				Name:  proto.String(name),
+				Value: proto.String(values[name]),
+			})
+		}
+		// a counter sample is cumulative already, the later one wins
+		points := mergePoints(s.Points, ts.Type != dto.MetricType_COUNTER)
+		sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+		for _, p := range points {
+			m := &dto.Metric{
+				Label:       labels,
+				TimestampMs: proto.Int64(p.Time.UnixMilli()),
+			}
+			if ts.Type == dto.MetricType_COUNTER {
+				m.Counter = &dto.Counter{Value: proto.Float64(p.Value)}
+			} else {
+				m.Gauge = &dto.Gauge{Value: proto.Float64(p.Value)}
+			}
+			mf.Metric = append(mf.Metric, m)
+		}
+	}
+	labelAccounts(mf)
+	return mf
+}
+
+// writeOpenMetrics - write the families in OpenMetrics text format, terminated by # EOF
+func writeOpenMetrics(w io.Writer, tss ...*timeSeries) error {
+	enc := expfmt.NewEncoder(w, expfmt.FmtOpenMetrics)
+	for _, ts := range tss {
+		mf := ts.family()
+		if len(mf.Metric) == 0 {
+			continue
+		}
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	return enc.(expfmt.Closer).Close()
+}
+
+// timeSeriesHandler - serve the timestamped samples in OpenMetrics format
+func timeSeriesHandler(tss ...*timeSeries) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", string(expfmt.FmtOpenMetrics))
+		if err := writeOpenMetrics(w, tss...); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_MergePoints(t *testing.T) {
+	t1, t2 := time.Unix(60, 0), time.Unix(120, 0)
+	points := []point{{t2, 1}, {t1, 2}, {t2, 3}}
+	for _, c := range []struct {
+		Sum    bool
+		Points []point
+	}{
+		{true, []point{{t2, 4}, {t1, 2}}},
+		{false, []point{{t2, 3}, {t1, 2}}},
+	} {
+		equals(t, c.Points, mergePoints(points, c.Sum))
+	}
+}
+
+func Test_TimeSeriesFamily(t *testing.T) {
+	reset(t)
+	defer func(l map[string]string) { ConstLabels = l }(ConstLabels)
+	ConstLabels = map[string]string{"cluster": "a"}
+
+	ts := newTimeSeries("test_per_minute", "help")
+	ts.Add(map[string]string{"item_id": "2"}, time.Unix(120, 0), 1)
+	ts.Add(map[string]string{"item_id": "1"}, time.Unix(120, 0), 1)
+	ts.Add(map[string]string{"item_id": "1"}, time.Unix(60, 0), 2)
+	ts.Add(map[string]string{"item_id": "1"}, time.Unix(120, 0), 3)
+
+	mf := ts.family()
+	equals(t, metricName("test_per_minute"), mf.GetName())
+	type sample struct {
+		Labels map[string]string
+		Time   int64
+		Value  float64
+	}
+	samples := []sample{}
+	for _, m := range mf.GetMetric() {
+		labels := map[string]string{}
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		samples = append(samples, sample{labels, m.GetTimestampMs(), m.GetGauge().GetValue()})
+	}
+	// series sorted by labels, points by time, the gauges of the same time are summed up
+	equals(t, []sample{
+		{map[string]string{"cluster": "a", "item_id": "1"}, 60000, 2},
+		{map[string]string{"cluster": "a", "item_id": "1"}, 120000, 4},
+		{map[string]string{"cluster": "a", "item_id": "2"}, 120000, 1},
+	}, samples)
+
+	cs := newCounterSeries("test_total", "help")
+	cs.Add(map[string]string{"item_id": "1"}, time.Unix(60, 0), 2)
+	cs.Add(map[string]string{"item_id": "1"}, time.Unix(60, 0), 5)
+	m := cs.family().GetMetric()
+	equals(t, 1, len(m))
+	equals(t, 5.0, m[0].GetCounter().GetValue())
+}
+
+func Test_TimeSeriesReplace(t *testing.T) {
+	ts := newTimeSeries("test_per_minute", "help")
+	ts.Add(map[string]string{"project_id": "1", "item_id": "1"}, time.Unix(60, 0), 1)
+	ts.Add(map[string]string{"project_id": "2", "item_id": "1"}, time.Unix(60, 0), 1)
+	ts.Replace(map[string]string{"project_id": "1"}, []series{
+		{Labels: map[string]string{"project_id": "1", "item_id": "2"}, Points: []point{{time.Unix(120, 0), 3}}},
+	})
+	equals(t, 2, len(ts.series))
+	equals(t, []point{{time.Unix(120, 0), 3}}, ts.series[seriesKey(map[string]string{"project_id": "1", "item_id": "2"})].Points)
+}
+
+func Test_WriteOpenMetrics(t *testing.T) {
+	reset(t)
+	defer func(n, s string, legacy bool, l map[string]string) {
+		MetricsNamespace, MetricsSubsystem, LegacyMetricNames, ConstLabels = n, s, legacy, l
+	}(MetricsNamespace, MetricsSubsystem, LegacyMetricNames, ConstLabels)
+	MetricsNamespace, MetricsSubsystem, LegacyMetricNames, ConstLabels = "rollbar", "", false, nil
+
+	ts := newTimeSeries("item_occurrences_per_minute", "This is the occurrences of an item per minute")
+	ts.Add(map[string]string{"item_id": "1"}, time.Unix(120, 0), 3)
+	ts.Add(map[string]string{"item_id": "1"}, time.Unix(60, 0), 2)
+	empty := newTimeSeries("empty", "no samples")
+
+	var buf bytes.Buffer
+	ok(t, writeOpenMetrics(&buf, ts, empty))
+	equals(t, `# HELP rollbar_item_occurrences_per_minute This is the occurrences of an item per minute
+# TYPE rollbar_item_occurrences_per_minute gauge
+rollbar_item_occurrences_per_minute{item_id="1"} 2.0 60.0
+rollbar_item_occurrences_per_minute{item_id="1"} 3.0 120.0
+# EOF
+`, buf.String())
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+	"github.com/sirupsen/logrus"
+)
+
+// flags of the tokens command
+var tokensOpts struct {
+	Projects string
+	Revoke   bool
+	Yes      bool
+	Output   string
+}
+
+func tokensFlags(fs *flag.FlagSet) {
+	fs.StringVar(&tokensOpts.Projects, "project", "", "project IDs or names to audit, comma separated, all the selected ones if empty")
+	fs.BoolVar(&tokensOpts.Revoke, "revoke", false, "revoke the tokens created by the exporter, needs -yes to take effect")
+	fs.BoolVar(&tokensOpts.Yes, "yes", false, "confirm -revoke, otherwise the tokens to revoke are only listed")
+	fs.StringVar(&tokensOpts.Output, "output", "table", "table or json")
+}
+
+// tokenAudit - a project access token as seen by the exporter
+type tokenAudit struct {
+	ProjectID            int             `json:"project_id"`
+	Project              string          `json:"project"`
+	Name                 string          `json:"name"`
+	Token                string          `json:"token"`
+	Scopes               []rollbar.Scope `json:"scopes"`
+	Status               rollbar.Status  `json:"status"`
+	RateLimitWindowSize  int             `json:"rate_limit_window_size"`
+	RateLimitWindowCount int             `json:"rate_limit_window_count"`
+	InUse                bool            `json:"in_use"`
+	CreatedByExporter    bool            `json:"created_by_exporter"`
+	Revoked              bool            `json:"revoked"`
+}
+
+// maskToken - only the last 4 characters of the token
+func maskToken(token string) string {
+	if len(token) <= 4 {
+		return token
+	}
+	return strings.Repeat("*", len(token)-4) + token[len(token)-4:]
+}
+
+// tokensCommand - list the access tokens of the projects, and which one the exporter would use
+func tokensCommand(args []string) error {
+	if tokensOpts.Output != "table" && tokensOpts.Output != "json" {
+		return fmt.Errorf("unknown output %q", tokensOpts.Output)
+	}
+	if err := configure(); err != nil {
+		return err
+	}
+	if tokensOpts.Revoke && ReadOnly {
+		return fmt.Errorf("tokens can't be revoked in read only mode")
+	}
+	if tokensOpts.Revoke && rollbar.ExporterToken.Marker == "" {
+		return fmt.Errorf("tokens can't be revoked without token_marker, the ones named %s may not be created by the exporter", rollbar.ExporterToken.Name)
+	}
+	for _, a := range accounts {
+		if !tokensOpts.Revoke || a.WriteAccessToken() != "" {
+			continue
+		}
+		if multiAccount() {
+			return fmt.Errorf("the write token of account %s is required to revoke tokens", a.Name)
+		}
+		return fmt.Errorf("$ROLLBAR_ACCOUNT_WRITE_TOKEN or $ROLLBAR_ACCOUNT_WRITE_TOKEN_FILE is required to revoke tokens")
+	}
+	for _, p := range strings.Split(tokensOpts.Projects, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			onlyProjects[p] = true
+		}
+	}
+
+	ps, err := listProjects()
+	if err != nil {
+		return err
+	}
+	resolveOverrides(ps)
+
+	audits := make([]tokenAudit, 0)
+	failedProjects, failedTokens := 0, 0
+	for _, p := range ps {
+		if !selectProject(p) {
+			continue
+		}
+		tokens, err := accountOf(p.ID).ListProjectAccessTokens(p.ID)
+		if err != nil {
+			logrus.Errorf("ListProjectAccessTokens failed - project: [%d]%s, %v", p.ID, p.Name, err)
+			failedProjects++
+			continue
+		}
+		used, _ := rollbar.SelectReadToken(tokens)
+		if token, ok := provisionedToken(p); ok {
+			used = &rollbar.ProjectAccessToken{AccessToken: token}
+		}
+		for _, t := range tokens {
+			a := tokenAudit{
+				ProjectID:            p.ID,
+				Project:              p.Name,
+				Name:                 t.Name,
+				Token:                maskToken(t.AccessToken),
+				Scopes:               t.Scopes,
+				Status:               t.Status,
+				RateLimitWindowSize:  t.RateLimitWindowSize,
+				RateLimitWindowCount: t.RateLimitWindowCount,
+				InUse:                used != nil && used.AccessToken == t.AccessToken,
+				CreatedByExporter:    t.CreatedByExporter(),
+			}
+			if tokensOpts.Revoke && a.CreatedByExporter {
+				if !tokensOpts.Yes {
+					logrus.Infof("would revoke token %s of project [%d]%s, add -yes to revoke", a.Token, p.ID, p.Name)
+				} else if err := accountOf(p.ID).DeleteProjectAccessToken(p.ID, t.AccessToken); err != nil {
+					logrus.Errorf("DeleteProjectAccessToken failed - project: [%d]%s, %v", p.ID, p.Name, err)
+					failedTokens++
+				} else {
+					logrus.Infof("revoked token %s of project [%d]%s", a.Token, p.ID, p.Name)
+					a.Revoked = true
+				}
+			}
+			audits = append(audits, a)
+		}
+	}
+
+	if tokensOpts.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(audits); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PROJECT_ID\tPROJECT\tNAME\tTOKEN\tSCOPES\tSTATUS\tRATE_LIMIT\tIN_USE\tEXPORTER\tREVOKED")
+		for _, a := range audits {
+			scopes := make([]string, 0, len(a.Scopes))
+			for _, s := range a.Scopes {
+				scopes = append(scopes, string(s))
+			}
+			rateLimit := "-"
+			if a.RateLimitWindowSize > 0 {
+				rateLimit = fmt.Sprintf("%d/%ds", a.RateLimitWindowCount, a.RateLimitWindowSize)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%t\t%t\t%t\n",
+				a.ProjectID, a.Project, a.Name, a.Token, strings.Join(scopes, ","), a.Status,
+				rateLimit, a.InUse, a.CreatedByExporter, a.Revoked)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	failures := make([]string, 0, 2)
+	if failedProjects > 0 {
+		failures = append(failures, fmt.Sprintf("the tokens of %d projects failed to be listed", failedProjects))
+	}
+	if failedTokens > 0 {
+		failures = append(failures, fmt.Sprintf("%d tokens failed to be revoked", failedTokens))
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bin3377/rollbar-open-metrics-exporter/internal/rollbar"
+)
+
+// fakeTokens - an API of projects 1 and 2, project 1 with a token of the exporter and one of someone,
+// the tokens of project 2 failing to be listed, and revoking answered with status
+func fakeTokens(revoked *[]string, status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/projects":
+			fmt.Fprint(w, `{"err":0,"result":[{"id":1,"name":"app","status":"enabled"},{"id":2,"name":"web","status":"enabled"}]}`)
+		case r.URL.Path == "/project/1/access_tokens":
+			fmt.Fprintf(w, `{"err":0,"result":[{"name":"read","scopes":["read"],"status":"enabled","access_token":"human"},`+
+				`{"name":"read %s","scopes":["read"],"status":"enabled","access_token":"owned"}]}`, rollbar.DefaultTokenMarker)
+		case strings.HasPrefix(r.URL.Path, "/project/1/access_token/"):
+			*revoked = append(*revoked, strings.TrimPrefix(r.URL.Path, "/project/1/access_token/"))
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"err":0}`)
+		default:
+			http.Error(w, "failed", http.StatusInternalServerError)
+		}
+	}
+}
+
+func Test_TokensRevoke(t *testing.T) {
+	reset(t)
+	restoreConfig(t)
+	defer func(options rollbar.TokenOptions) {
+		rollbar.ExporterToken = options
+		tokensOpts.Revoke, tokensOpts.Yes, tokensOpts.Output = false, false, "table"
+	}(rollbar.ExporterToken)
+	flagValues = map[string]string{}
+	t.Setenv("ROLLBAR_ACCOUNT_READ_TOKEN", "x")
+	t.Setenv("ROLLBAR_ACCOUNT_WRITE_TOKEN", "y")
+
+	for _, c := range []struct {
+		Name    string
+		Marker  string
+		Yes     bool
+		Status  int
+		Revoked []string
+		Error   string
+	}{
+		{"no marker", "", true, http.StatusOK, nil, "tokens can't be revoked without token_marker"},
+		{"dry run", rollbar.DefaultTokenMarker, false, http.StatusOK, nil, "the tokens of 1 projects failed to be listed"},
+		{"revoked", rollbar.DefaultTokenMarker, true, http.StatusOK, []string{"owned"}, "the tokens of 1 projects failed to be listed"},
+		{"revoke failed", rollbar.DefaultTokenMarker, true, http.StatusInternalServerError, []string{"owned"},
+			"the tokens of 1 projects failed to be listed, 1 tokens failed to be revoked"},
+	} {
+		var revoked []string
+		serve(t, fakeTokens(&revoked, c.Status))
+		rollbar.ExporterToken.Marker = c.Marker
+		tokensOpts.Revoke, tokensOpts.Yes, tokensOpts.Output = true, c.Yes, "json"
+
+		err := tokensCommand(nil)
+		assert(t, err != nil && strings.HasPrefix(err.Error(), c.Error), "%s: expected %q, got %v", c.Name, c.Error, err)
+		equals(t, c.Revoked, revoked)
+	}
+}